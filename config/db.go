@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
-	"gorm.io/driver/mysql"
+	"github.com/go-sql-driver/mysql"
+	gormmysql "gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
 
@@ -15,18 +17,60 @@ var DB *gorm.DB
 func ConnectDatabase() {
 	dsn := os.Getenv("DATABASE_DSN")
 	if dsn == "" {
-		dsn = "root:@tcp(127.0.0.1:3306)/face_lock_backend?charset=utf8mb4&parseTime=True&loc=Local"
+		dsn = "root:@tcp(127.0.0.1:3306)/face_lock_backend?charset=utf8mb4&parseTime=True&loc=UTC"
 	}
 
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	dsn, err := utcDSN(dsn)
+	if err != nil {
+		log.Fatal("Invalid DATABASE_DSN:", err)
+	}
+
+	db, err := gorm.Open(gormmysql.Open(dsn), &gorm.Config{
+		NowFunc: func() time.Time { return time.Now().UTC() },
+	})
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 
-	if err := db.AutoMigrate(&models.Log{}, &models.User{}); err != nil {
+	if err := db.AutoMigrate(
+		&models.Log{},
+		&models.User{},
+		&models.LogExport{},
+		&models.LogChainHead{},
+		&models.LogCheckpoint{},
+		&models.LogTombstone{},
+		&models.LogEvidence{},
+		&models.LogNote{},
+		&models.LogTag{},
+		&models.AttendanceMember{},
+		&models.Anomaly{},
+		&models.IdentityBaseline{},
+		&models.WsEvent{},
+		&models.WsBroadcast{},
+	); err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
 
+	if err := backfillLogOccurredAt(db); err != nil {
+		log.Fatal("Failed to backfill log timestamps:", err)
+	}
+
+	if err := chainUnchainedLogs(db); err != nil {
+		log.Fatal("Failed to build log hash chain:", err)
+	}
+
 	DB = db
 	fmt.Println("Database connected successfully")
 }
+
+// utcDSN forces the driver to read and write DATETIME columns as UTC,
+// whatever loc the configured DSN asks for.
+func utcDSN(dsn string) (string, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", err
+	}
+	cfg.ParseTime = true
+	cfg.Loc = time.UTC
+	return cfg.FormatDSN(), nil
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"comproBackend/models"
+	"errors"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const backfillBatchSize = 500
+
+// backfillLogOccurredAt fills occurred_at for rows written before the column
+// existed by parsing their free-form timestamp string. Rows whose timestamp
+// cannot be parsed fall back to created_at so the loop always terminates.
+func backfillLogOccurredAt(db *gorm.DB) error {
+	var total int
+	for {
+		var rows []struct {
+			ID        uint
+			Timestamp string
+			CreatedAt time.Time
+		}
+		if err := db.Unscoped().Model(&models.Log{}).
+			Select("id", "timestamp", "created_at").
+			Where("occurred_at IS NULL").
+			Order("id").
+			Limit(backfillBatchSize).
+			Find(&rows).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			break
+		}
+
+		for _, row := range rows {
+			occurredAt, err := models.ParseTimestamp(row.Timestamp, models.NaiveTimestampLocation)
+			if err != nil {
+				log.Printf("[MIGRATION] Log %d: %v, using created_at", row.ID, err)
+				occurredAt = row.CreatedAt.UTC()
+			}
+
+			if err := db.Unscoped().Model(&models.Log{}).
+				Where("id = ?", row.ID).
+				UpdateColumn("occurred_at", occurredAt.Truncate(time.Millisecond)).Error; err != nil {
+				return err
+			}
+		}
+		total += len(rows)
+	}
+
+	if total > 0 {
+		log.Printf("[MIGRATION] Backfilled occurred_at for %d logs", total)
+	}
+	return nil
+}
+
+// chainUnchainedLogs creates the chain head row and appends every log that
+// existed before the hash chain was introduced, oldest first. It runs once: a
+// database whose chain head already exists is left alone, so a row inserted
+// behind the backend's back is reported by VerifyLogChain instead of being
+// signed into the chain at the next boot. An interrupted backfill resumes.
+func chainUnchainedLogs(db *gorm.DB) error {
+	var head models.LogChainHead
+	err := db.First(&head, 1).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		head = models.LogChainHead{ID: 1, BackfillPending: true}
+		err = db.Create(&head).Error
+	}
+	if err != nil {
+		return err
+	}
+	if !head.BackfillPending {
+		return nil
+	}
+
+	var total int
+	for {
+		var batch []models.Log
+		if err := db.Unscoped().Where("chain_seq IS NULL").Order("id").Limit(backfillBatchSize).Find(&batch).Error; err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			for i := range batch {
+				if err := models.AppendToChain(tx, &batch[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		total += len(batch)
+	}
+
+	if total > 0 {
+		log.Printf("[MIGRATION] Added %d existing logs to the hash chain", total)
+	}
+	return db.Model(&models.LogChainHead{}).Where("id = ?", 1).Update("backfill_pending", false).Error
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"comproBackend/models"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const defaultSiteTimezone = "Asia/Jakarta"
+
+// SiteLocation is the timezone of the building the door is installed in. Day
+// boundaries and local timestamps in API responses are computed in it unless a
+// request overrides it with ?tz=.
+var SiteLocation = time.UTC
+
+var locationCache sync.Map
+
+// LoadSiteTimezone reads SITE_TIMEZONE (an IANA name such as "Europe/Berlin")
+// and falls back to Asia/Jakarta when it is unset or unknown.
+func LoadSiteTimezone() {
+	name := os.Getenv("SITE_TIMEZONE")
+	if name == "" {
+		name = defaultSiteTimezone
+	}
+
+	loc, err := LoadLocation(name)
+	if err != nil {
+		log.Printf("Warning: unknown SITE_TIMEZONE %q, using %s", name, defaultSiteTimezone)
+		if loc, err = LoadLocation(defaultSiteTimezone); err != nil {
+			loc = time.FixedZone(defaultSiteTimezone, 7*60*60)
+		}
+	}
+
+	SiteLocation = loc
+	models.NaiveTimestampLocation = loc
+	log.Printf("Site timezone: %s", loc)
+}
+
+// LoadLocation is time.LoadLocation with a cache, since the zoneinfo lookup
+// reads from disk on every call.
+func LoadLocation(name string) (*time.Location, error) {
+	if loc, ok := locationCache.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locationCache.Store(name, loc)
+	return loc, nil
+}
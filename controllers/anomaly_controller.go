@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"comproBackend/config"
+	"comproBackend/events"
+	"comproBackend/models"
+	"comproBackend/services"
+	"comproBackend/utils"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetAnomalies lists raised anomalies, newest first. Optional filters: kind,
+// status, name and limit (default 100, max 500).
+func GetAnomalies(c *gin.Context) {
+	limit := 100
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 500 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+			return
+		}
+		limit = n
+	}
+
+	query := config.DB.Model(&models.Anomaly{})
+	if kind := c.Query("kind"); kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+	if status := c.Query("status"); status != "" {
+		if !slices.Contains(services.AnomalyStatuses, status) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status. Use open, acknowledged or dismissed"})
+			return
+		}
+		query = query.Where("status = ?", status)
+	}
+	if name := c.Query("name"); name != "" {
+		query = query.Where("name = ?", name)
+	}
+
+	var anomalies []models.Anomaly
+	if err := query.Order("id DESC").Limit(limit).Find(&anomalies).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range anomalies {
+		anomalies[i].OccurredAt = anomalies[i].OccurredAt.In(config.SiteLocation)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": anomalies, "count": len(anomalies)})
+}
+
+// ReviewAnomaly acknowledges or dismisses an anomaly, or reopens it.
+func ReviewAnomaly(c *gin.Context) {
+	var input struct {
+		Status string `json:"status" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !slices.Contains(services.AnomalyStatuses, input.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status. Use open, acknowledged or dismissed"})
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	anomaly, err := services.ReviewAnomaly(uint(id), input.Status, c.GetString("username"))
+	if err != nil {
+		if errors.Is(err, services.ErrAnomalyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Anomaly not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	utils.Publish(events.New(events.TypeAnomalyReview, events.SourceBackend, events.NewAnomalyReviewPayload(anomaly)))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Anomaly updated", "data": anomaly})
+}
+
+// GetIdentityBaselines returns the learned per-identity baselines. Hours are
+// in the site timezone.
+func GetIdentityBaselines(c *gin.Context) {
+	var baselines []models.IdentityBaseline
+	if err := config.DB.Order("name").Find(&baselines).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": baselines, "timezone": config.SiteLocation.String()})
+}
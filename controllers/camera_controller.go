@@ -1,333 +1,308 @@
 package controllers
 
 import (
-	"bufio"
-	"bytes"
-	"comproBackend/config"
-	"comproBackend/models"
+	"comproBackend/events"
+	"comproBackend/facesvc"
+	"comproBackend/services"
 	"comproBackend/utils"
 	"encoding/json"
-	"io"
-	"log"
+	"mime/multipart"
 	"net/http"
-	"strings"
+	"net/textproto"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-const pythonBaseURL = "http://localhost:5000"
+const (
+	streamFirstFrameTimeout = 10 * time.Second
+	maxStreamFPS            = 60
+	minStreamWidth          = 16
+	maxStreamWidth          = 3840
+)
 
+// ProxyCameraStream serves the camera as MJPEG. Viewers share one upstream
+// connection per camera_id; fps and width ask for a lower frame rate or a
+// scaled-down variant.
 func ProxyCameraStream(c *gin.Context) {
-	resp, err := http.Get(pythonBaseURL + "/api/camera/stream")
-	if err != nil {
-		log.Println("Camera stream error:", err)
-		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to connect to camera service"})
+	var opts services.StreamOptions
+	if v := c.Query("fps"); v != "" {
+		fps, err := strconv.ParseFloat(v, 64)
+		if err != nil || fps <= 0 || fps > maxStreamFPS {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid fps"})
+			return
+		}
+		opts.FPS = fps
+	}
+	if v := c.Query("width"); v != "" {
+		width, err := strconv.Atoi(v)
+		if err != nil || width < minStreamWidth || width > maxStreamWidth {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid width"})
+			return
+		}
+		opts.Width = width
+	}
+
+	viewer := services.WatchCamera(c.Query("camera_id"), opts)
+	defer viewer.Close()
+
+	ctx := c.Request.Context()
+	frame := viewer.Next(ctx, streamFirstFrameTimeout)
+	if frame == nil {
+		if ctx.Err() == nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to connect to camera service"})
+		}
 		return
 	}
-	defer resp.Body.Close()
 
-	c.Header("Content-Type", resp.Header.Get("Content-Type"))
+	mw := multipart.NewWriter(c.Writer)
+	c.Header("Content-Type", "multipart/x-mixed-replace; boundary="+mw.Boundary())
 	c.Header("Cache-Control", "no-cache")
 	c.Header("Connection", "keep-alive")
-	io.Copy(c.Writer, resp.Body)
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	for frame != nil {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "image/jpeg")
+		header.Set("Content-Length", strconv.Itoa(len(frame)))
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			return
+		}
+		if _, err := part.Write(frame); err != nil {
+			return
+		}
+		c.Writer.Flush()
+		frame = viewer.Next(ctx, 0)
+	}
 }
 
 func ProxyCameraSnapshot(c *gin.Context) {
-	resp, err := http.Get(pythonBaseURL + "/api/camera/snapshot")
+	frame, err := facesvc.Default().Snapshot(c.Request.Context())
 	if err != nil {
-		log.Println("Camera snapshot error:", err)
-		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to get snapshot"})
+		faceServiceError(c, err, "Failed to get snapshot")
 		return
 	}
-	defer resp.Body.Close()
-
-	c.Header("Content-Type", resp.Header.Get("Content-Type"))
-	io.Copy(c.Writer, resp.Body)
+	c.Data(http.StatusOK, "image/jpeg", frame)
 }
 
+// ProxyCameraEvents relays the camera service event stream. All clients share
+// the backend's single upstream connection, see
+// services.StartCameraEventConsumer.
 func ProxyCameraEvents(c *gin.Context) {
-	resp, err := http.Get(pythonBaseURL + "/api/camera/events")
-	if err != nil {
-		log.Println("Camera events error:", err)
-		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to connect to camera events"})
-		return
-	}
-	defer resp.Body.Close()
+	stream, unsubscribe := services.SubscribeCameraEvents(streamBuffer)
+	defer unsubscribe()
 
 	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
 	c.Header("Connection", "keep-alive")
 	c.Header("Access-Control-Allow-Origin", "*")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
 
-	flusher, ok := c.Writer.(http.Flusher)
-	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Streaming not supported"})
-		return
-	}
-
-	reader := bufio.NewReader(resp.Body)
+	ctx := c.Request.Context()
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
 	for {
-		line, err := reader.ReadBytes('\n')
-		if err != nil {
-			if err != io.EOF {
-				log.Println("SSE read error:", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			if _, err := c.Writer.WriteString(": ping\n\n"); err != nil {
+				return
 			}
-			break
-		}
-
-		// Write to SSE client
-		c.Writer.Write(line)
-		flusher.Flush()
-
-		// Parse and broadcast detection events to WebSocket clients
-		lineStr := string(line)
-		if strings.HasPrefix(lineStr, "data: ") {
-			jsonData := strings.TrimPrefix(lineStr, "data: ")
-			jsonData = strings.TrimSpace(jsonData)
-
-			var event map[string]interface{}
-			if err := json.Unmarshal([]byte(jsonData), &event); err == nil {
-				// Broadcast to WebSocket clients
-				utils.BroadcastEvent(event)
-
-				// If it's a detection event, save to database
-				if eventType, ok := event["type"].(string); ok && eventType == "detection" {
-					go saveDetectionLog(event)
-				}
+			c.Writer.Flush()
+		case block, ok := <-stream:
+			if !ok {
+				return // fell too far behind
+			}
+			if _, err := c.Writer.Write(block); err != nil {
+				return
 			}
+			c.Writer.Flush()
 		}
 	}
 }
 
-func saveDetectionLog(event map[string]interface{}) {
-	data, ok := event["data"].(map[string]interface{})
-	if !ok {
-		return
-	}
-
-	name, _ := data["name"].(string)
-	if name == "" || name == "Unknown" {
-		return // Don't save unknown detections
-	}
-
-	authorized, _ := data["authorized"].(bool)
-	confidence, _ := data["confidence"].(float64)
-	role, _ := data["role"].(string)
-	timestamp, _ := data["timestamp"].(string)
-
-	if timestamp == "" {
-		timestamp = time.Now().Format(time.RFC3339)
-	}
-	if role == "" {
-		role = "Guest"
-	}
-
-	logEntry := models.Log{
-		Authorized: authorized,
-		Confidence: confidence,
-		Name:       name,
-		Role:       role,
-		Timestamp:  timestamp,
-	}
-
-	if err := config.DB.Create(&logEntry).Error; err != nil {
-		log.Println("Failed to save detection log:", err)
-	} else {
-		log.Printf("Detection logged: %s (authorized: %v, confidence: %.2f)", name, authorized, confidence)
-	}
-}
-
 func GetCameraStatus(c *gin.Context) {
-	resp, err := http.Get(pythonBaseURL + "/api/camera/status")
+	result, err := facesvc.Default().Status(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to get camera status"})
+		faceServiceError(c, err, "Failed to get camera status")
 		return
 	}
-	defer resp.Body.Close()
-
-	var result map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&result)
-	c.JSON(resp.StatusCode, result)
+	c.JSON(http.StatusOK, result)
 }
 
 func StartCamera(c *gin.Context) {
-	resp, err := http.Post(pythonBaseURL+"/api/camera/start", "application/json", nil)
+	result, err := facesvc.Default().StartCamera(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to start camera"})
+		faceServiceError(c, err, "Failed to start camera")
 		return
 	}
-	defer resp.Body.Close()
-
-	var result map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&result)
-	c.JSON(resp.StatusCode, result)
+	utils.Publish(events.New(events.TypeCameraStatus, events.SourceBackend, events.CameraStatusPayload{Action: "start", Result: result}))
+	c.JSON(http.StatusOK, result)
 }
 
 func StopCamera(c *gin.Context) {
-	resp, err := http.Post(pythonBaseURL+"/api/camera/stop", "application/json", nil)
+	result, err := facesvc.Default().StopCamera(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to stop camera"})
+		faceServiceError(c, err, "Failed to stop camera")
 		return
 	}
-	defer resp.Body.Close()
-
-	var result map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&result)
-	c.JSON(resp.StatusCode, result)
+	utils.Publish(events.New(events.TypeCameraStatus, events.SourceBackend, events.CameraStatusPayload{Action: "stop", Result: result}))
+	c.JSON(http.StatusOK, result)
 }
 
 func GetCameraConfig(c *gin.Context) {
-	resp, err := http.Get(pythonBaseURL + "/api/camera/config")
+	doc, err := facesvc.Default().Config(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to get camera config"})
+		faceServiceError(c, err, "Failed to get camera config")
 		return
 	}
-	defer resp.Body.Close()
-
-	var result map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&result)
-	c.JSON(resp.StatusCode, result)
+	c.JSON(http.StatusOK, doc)
 }
 
 func UpdateCameraConfig(c *gin.Context) {
-	body, _ := io.ReadAll(c.Request.Body)
-	resp, err := http.Post(pythonBaseURL+"/api/camera/config", "application/json", bytes.NewReader(body))
+	doc, ok := bindDocument(c)
+	if !ok {
+		return
+	}
+	result, err := facesvc.Default().UpdateConfig(c.Request.Context(), doc)
 	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to update camera config"})
+		faceServiceError(c, err, "Failed to update camera config")
 		return
 	}
-	defer resp.Body.Close()
-
-	var result map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&result)
-	c.JSON(resp.StatusCode, result)
+	c.JSON(http.StatusOK, result)
 }
 
 func GetCameraZones(c *gin.Context) {
-	resp, err := http.Get(pythonBaseURL + "/api/camera/zones")
+	doc, err := facesvc.Default().Zones(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to get camera zones"})
+		faceServiceError(c, err, "Failed to get camera zones")
 		return
 	}
-	defer resp.Body.Close()
-
-	var result map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&result)
-	c.JSON(resp.StatusCode, result)
+	c.JSON(http.StatusOK, doc)
 }
 
 func UpdateCameraZones(c *gin.Context) {
-	body, _ := io.ReadAll(c.Request.Body)
-	resp, err := http.Post(pythonBaseURL+"/api/camera/zones", "application/json", bytes.NewReader(body))
+	doc, ok := bindDocument(c)
+	if !ok {
+		return
+	}
+	result, err := facesvc.Default().UpdateZones(c.Request.Context(), doc)
 	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to update camera zones"})
+		faceServiceError(c, err, "Failed to update camera zones")
 		return
 	}
-	defer resp.Body.Close()
-
-	var result map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&result)
-	c.JSON(resp.StatusCode, result)
+	c.JSON(http.StatusOK, result)
 }
 
 func SetupDroidCam(c *gin.Context) {
-	body, _ := io.ReadAll(c.Request.Body)
-	resp, err := http.Post(pythonBaseURL+"/api/camera/test/droidcam", "application/json", bytes.NewReader(body))
+	var req facesvc.DroidCamRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	result, err := facesvc.Default().SetupDroidCam(c.Request.Context(), req)
 	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to setup DroidCam"})
+		faceServiceError(c, err, "Failed to setup DroidCam")
 		return
 	}
-	defer resp.Body.Close()
-
-	var result map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&result)
-	c.JSON(resp.StatusCode, result)
+	c.JSON(http.StatusOK, result)
 }
 
 func SetupRTSP(c *gin.Context) {
-	body, _ := io.ReadAll(c.Request.Body)
-	resp, err := http.Post(pythonBaseURL+"/api/camera/test/rtsp", "application/json", bytes.NewReader(body))
+	var req facesvc.RTSPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	result, err := facesvc.Default().SetupRTSP(c.Request.Context(), req)
 	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to setup RTSP"})
+		faceServiceError(c, err, "Failed to setup RTSP")
 		return
 	}
-	defer resp.Body.Close()
-
-	var result map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&result)
-	c.JSON(resp.StatusCode, result)
+	c.JSON(http.StatusOK, result)
 }
 
 func ListFaceUsers(c *gin.Context) {
-	resp, err := http.Get(pythonBaseURL + "/api/users")
+	users, err := facesvc.Default().Users(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to get users from face recognition service"})
+		faceServiceError(c, err, "Failed to get users from face recognition service")
 		return
 	}
-	defer resp.Body.Close()
-
-	var result map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&result)
-	c.JSON(resp.StatusCode, result)
+	if users == nil {
+		users = []facesvc.FaceUser{}
+	}
+	c.JSON(http.StatusOK, facesvc.UsersResponse{Users: users})
 }
 
 func EnrollFaceUser(c *gin.Context) {
-	body, _ := io.ReadAll(c.Request.Body)
-	resp, err := http.Post(pythonBaseURL+"/api/enroll", "application/json", bytes.NewReader(body))
+	var req facesvc.EnrollRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	result, err := facesvc.Default().Enroll(c.Request.Context(), req)
 	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to enroll user"})
+		faceServiceError(c, err, "Failed to enroll user")
 		return
 	}
-	defer resp.Body.Close()
-
-	var result map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&result)
-	c.JSON(resp.StatusCode, result)
+	c.JSON(http.StatusOK, result)
 }
 
 func EnrollFaceUserCapture(c *gin.Context) {
-	body, _ := io.ReadAll(c.Request.Body)
-	resp, err := http.Post(pythonBaseURL+"/api/enroll/capture", "application/json", bytes.NewReader(body))
+	var req facesvc.CaptureEnrollRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	result, err := facesvc.Default().EnrollCapture(c.Request.Context(), req)
 	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to enroll user from capture"})
+		faceServiceError(c, err, "Failed to enroll user from capture")
 		return
 	}
-	defer resp.Body.Close()
-
-	var result map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&result)
-	c.JSON(resp.StatusCode, result)
+	c.JSON(http.StatusOK, result)
 }
 
 func DeleteFaceUser(c *gin.Context) {
-	name := c.Param("name")
-	req, _ := http.NewRequest(http.MethodDelete, pythonBaseURL+"/api/users/"+name, nil)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	result, err := facesvc.Default().DeleteUser(c.Request.Context(), c.Param("name"))
 	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to delete user"})
+		faceServiceError(c, err, "Failed to delete user")
 		return
 	}
-	defer resp.Body.Close()
-
-	var result map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&result)
-	c.JSON(resp.StatusCode, result)
+	c.JSON(http.StatusOK, result)
 }
 
 func AddFaceSample(c *gin.Context) {
-	name := c.Param("name")
-	body, _ := io.ReadAll(c.Request.Body)
-	resp, err := http.Post(pythonBaseURL+"/api/users/"+name+"/add-sample", "application/json", bytes.NewReader(body))
+	var req facesvc.AddSampleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	result, err := facesvc.Default().AddSample(c.Request.Context(), c.Param("name"), req)
 	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to add face sample"})
+		faceServiceError(c, err, "Failed to add face sample")
 		return
 	}
-	defer resp.Body.Close()
+	c.JSON(http.StatusOK, result)
+}
+
+// bindDocument reads a JSON body that is passed to the face service as is.
+func bindDocument(c *gin.Context) (facesvc.Document, bool) {
+	body, err := c.GetRawData()
+	if err != nil || !json.Valid(body) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON body"})
+		return nil, false
+	}
+	return body, true
+}
 
-	var result map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&result)
-	c.JSON(resp.StatusCode, result)
+// faceServiceError answers a failed face service call, see facesvc.Failure.
+func faceServiceError(c *gin.Context, err error, message string) {
+	status, body, _ := facesvc.Failure(err, message)
+	c.JSON(status, body)
 }
@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"comproBackend/config"
+	"comproBackend/models"
+	"comproBackend/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VerifyLogChain walks the whole log hash chain and reports the first broken
+// link.
+func VerifyLogChain(c *gin.Context) {
+	if c.GetString("role") != "verificator" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	report, err := services.VerifyLogChain()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": report})
+}
+
+// GetLogCheckpoints lists signed checkpoints and tombstones, newest first,
+// with the public key needed to check them.
+func GetLogCheckpoints(c *gin.Context) {
+	if c.GetString("role") != "verificator" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	var checkpoints []models.LogCheckpoint
+	if err := config.DB.Order("seq DESC").Limit(200).Find(&checkpoints).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var tombstones []models.LogTombstone
+	if err := config.DB.Order("from_seq DESC").Limit(200).Find(&tombstones).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"public_key":  services.SigningPublicKey(),
+		"key_id":      services.SigningKeyID(),
+		"checkpoints": checkpoints,
+		"tombstones":  tombstones,
+	})
+}
+
+// CreateLogCheckpoint signs the current chain head immediately.
+func CreateLogCheckpoint(c *gin.Context) {
+	if c.GetString("role") != "verificator" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	cp, err := services.CreateCheckpoint()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if cp == nil {
+		c.JSON(http.StatusOK, gin.H{"message": "Chain head is already signed"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Checkpoint created", "data": cp})
+}
@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"comproBackend/config"
+	"comproBackend/models"
+	"comproBackend/services"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetLogEvidence lists the snapshot, thumbnail and clip stored for a log.
+func GetLogEvidence(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	var Log models.Log
+	if err := config.DB.First(&Log, uint(id)).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Log not found"})
+		return
+	}
+
+	var evidence []models.LogEvidence
+	if err := config.DB.Where("log_id = ?", Log.ID).Order("id").Find(&evidence).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": evidence})
+}
+
+// GetLogEvidenceFile serves one evidence file. Clips are replayed as MJPEG at
+// their recorded frame rate unless download=true.
+func GetLogEvidenceFile(c *gin.Context) {
+	if services.Evidence == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Evidence capture is disabled"})
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	var Log models.Log
+	if err := config.DB.First(&Log, uint(id)).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Log not found"})
+		return
+	}
+
+	var evidence models.LogEvidence
+	if err := config.DB.Where("log_id = ? AND kind = ?", Log.ID, c.Param("kind")).First(&evidence).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Evidence not found"})
+		return
+	}
+
+	blob, err := services.Evidence.Open(evidence.BlobKey)
+	if err != nil {
+		if errors.Is(err, services.ErrBlobNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Evidence file is missing"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer blob.Close()
+
+	c.Header("Cache-Control", "private, max-age=3600")
+	c.Header("X-Content-SHA256", evidence.SHA256)
+
+	if evidence.Kind == "clip" && c.Query("download") != "true" {
+		c.Header("Content-Type", evidence.ContentType)
+		c.Status(http.StatusOK)
+		services.ReplayClip(c.Writer, c.Writer.Flush, blob)
+		return
+	}
+
+	if evidence.Kind == "clip" {
+		c.Header("Content-Disposition", `attachment; filename="log_`+strconv.FormatUint(uint64(Log.ID), 10)+`_clip.mjpeg"`)
+	}
+	c.Header("Content-Type", evidence.ContentType)
+	c.Header("Content-Length", strconv.FormatInt(evidence.Size, 10))
+	c.Status(http.StatusOK)
+	io.Copy(c.Writer, blob)
+}
@@ -0,0 +1,215 @@
+package controllers
+
+import (
+	"comproBackend/config"
+	"comproBackend/middleware"
+	"comproBackend/models"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	bulkTokenAudience = "log-bulk"
+	bulkTokenTTL      = 5 * time.Minute
+	maxBulkIDs        = 1000
+)
+
+// bulkClaims bind a confirmation token to one action on one selection of logs
+// for one user.
+type bulkClaims struct {
+	Action      string `json:"action"`
+	Selection   string `json:"selection"`
+	RequestedBy string `json:"requested_by"`
+	Count       int64  `json:"count"`
+	jwt.RegisteredClaims
+}
+
+// logSelection scopes a query to the logs targeted by a bulk operation.
+type logSelection func(*gorm.DB) *gorm.DB
+
+func byLogIDs(ids []uint) logSelection {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("id IN ?", ids)
+	}
+}
+
+// softDeleteLogs moves the selected logs to the trash, recording who deleted
+// them. Logs already in the trash are left alone. selection must not be
+// Unscoped, or Delete would remove the rows for good.
+func softDeleteLogs(selection logSelection, username string) (int64, error) {
+	var deleted int64
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Log{}).Scopes(selection).Where("deleted_at IS NULL").Update("deleted_by", username).Error; err != nil {
+			return err
+		}
+		result := tx.Scopes(selection).Where("deleted_at IS NULL").Delete(&models.Log{})
+		deleted = result.RowsAffected
+		return result.Error
+	})
+	return deleted, err
+}
+
+// restoreLogs takes the selected logs out of the trash.
+func restoreLogs(selection logSelection) (int64, error) {
+	result := config.DB.Unscoped().Model(&models.Log{}).Scopes(selection).
+		Where("deleted_at IS NOT NULL").
+		Updates(map[string]interface{}{"deleted_at": nil, "deleted_by": ""})
+	return result.RowsAffected, result.Error
+}
+
+// BulkDeleteLogs moves logs to the trash by ID list or by filter.
+func BulkDeleteLogs(c *gin.Context) {
+	bulkLogOperation(c, "delete")
+}
+
+// BulkRestoreLogs restores trashed logs by ID list or by filter.
+func BulkRestoreLogs(c *gin.Context) {
+	bulkLogOperation(c, "restore")
+}
+
+// bulkLogOperation works in two steps. Without a confirmation token it only
+// counts the selected logs and returns a short-lived token bound to the
+// action, the selection and the user. Repeating the identical request with
+// that token applies the action.
+//
+// Logs are selected by the "ids" body field, or when it is empty by the same
+// query parameters as /api/logs/filter.
+func bulkLogOperation(c *gin.Context, action string) {
+	var input struct {
+		IDs               []uint `json:"ids"`
+		ConfirmationToken string `json:"confirmation_token"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+	}
+	if len(input.IDs) > maxBulkIDs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Too many IDs, use a filter instead"})
+		return
+	}
+
+	var selection logSelection
+	var selectionKey string
+	if len(input.IDs) > 0 {
+		ids := append([]uint(nil), input.IDs...)
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		parts := make([]string, len(ids))
+		for i, id := range ids {
+			parts[i] = strconv.FormatUint(uint64(id), 10)
+		}
+		selection = byLogIDs(ids)
+		selectionKey = "ids:" + strings.Join(parts, ",")
+	} else {
+		loc, err := requestLocation(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		filters, err := parseLogFilters(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		start, end, err := parsePeriod(c, loc)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if action == "restore" {
+			filters.Deleted = "only"
+		} else {
+			// deleted=include|only would make the selection Unscoped and
+			// turn the soft delete into a permanent one
+			if c.Query("deleted") != "" && filters.Deleted != "exclude" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "deleted filter is not supported for bulk delete, use purge for trashed logs"})
+				return
+			}
+			filters.Deleted = "exclude"
+		}
+		selection = func(db *gorm.DB) *gorm.DB {
+			return filters.apply(db.Where("occurred_at >= ? AND occurred_at < ?", start, end))
+		}
+		// Encode sorts by key, so equivalent query strings hash the same
+		selectionKey = "filter:" + c.Request.URL.Query().Encode()
+	}
+
+	sum := sha256.Sum256([]byte(selectionKey))
+	selectionHash := hex.EncodeToString(sum[:])
+	username := c.GetString("username")
+
+	if input.ConfirmationToken == "" {
+		countQuery := config.DB.Model(&models.Log{}).Scopes(selection).Where("deleted_at IS NULL")
+		if action == "restore" {
+			countQuery = config.DB.Unscoped().Model(&models.Log{}).Scopes(selection).Where("deleted_at IS NOT NULL")
+		}
+		var count int64
+		if err := countQuery.Count(&count).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		expiresAt := time.Now().Add(bulkTokenTTL)
+		claims := &bulkClaims{
+			Action:      action,
+			Selection:   selectionHash,
+			RequestedBy: username,
+			Count:       count,
+			RegisteredClaims: jwt.RegisteredClaims{
+				Audience:  jwt.ClaimStrings{bulkTokenAudience},
+				ExpiresAt: jwt.NewNumericDate(expiresAt),
+				IssuedAt:  jwt.NewNumericDate(time.Now()),
+			},
+		}
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(middleware.JWTSecret)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate confirmation token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":            "Repeat this request with confirmation_token to " + action + " these logs",
+			"action":             action,
+			"count":              count,
+			"confirmation_token": token,
+			"expires_at":         expiresAt,
+		})
+		return
+	}
+
+	claims := &bulkClaims{}
+	token, err := jwt.ParseWithClaims(input.ConfirmationToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return middleware.JWTSecret, nil
+	}, jwt.WithAudience(bulkTokenAudience), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !token.Valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired confirmation token"})
+		return
+	}
+	if claims.Action != action || claims.Selection != selectionHash || claims.RequestedBy != username {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Confirmation token does not match this request"})
+		return
+	}
+
+	var affected int64
+	if action == "delete" {
+		affected, err = softDeleteLogs(selection, username)
+	} else {
+		affected, err = restoreLogs(selection)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Bulk " + action + " completed", "action": action, "affected": affected})
+}
@@ -4,123 +4,149 @@ import (
 	"comproBackend/config"
 	"comproBackend/models"
 	"comproBackend/services"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 func GetLogs(c *gin.Context) {
-	var Logs []models.Log
+	loc, err := requestLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filters, err := parseLogFilters(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := parseLogPage(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	if err := config.DB.Find(&Logs).Error; err != nil {
+	query := filters.apply(config.DB.Model(&models.Log{})).Session(&gorm.Session{})
+
+	Logs, total, nextCursor, err := fetchLogPage(query, page)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": Logs})
+	localizeLogs(Logs, loc)
+
+	c.JSON(http.StatusOK, gin.H{"data": Logs, "count": total, "timezone": loc.String(), "next_cursor": nextCursor, "has_more": nextCursor != ""})
 }
 
 func GetFilteredLogs(c *gin.Context) {
-	loc, err := time.LoadLocation("Asia/Jakarta")
+	loc, err := requestLocation(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load timezone"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	name := c.Query("name")
-	period := c.Query("period")
-	dateStr := c.Query("date")
-	startDateStr := c.Query("start")
-	endDateStr := c.Query("end")
-
-	var startStr, endStr string
-	var startLocal, endLocal time.Time
-	now := time.Now().In(loc)
-
-	switch period {
-	case "range":
-		if startDateStr == "" || endDateStr == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Start and end date parameters are required for 'range' period"})
-			return
-		}
-		startParsed, err := time.ParseInLocation("2006-01-02", startDateStr, loc)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start date format. Use YYYY-MM-DD"})
-			return
-		}
-		endParsed, err := time.ParseInLocation("2006-01-02", endDateStr, loc)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end date format. Use YYYY-MM-DD"})
-			return
-		}
-		startLocal = time.Date(startParsed.Year(), startParsed.Month(), startParsed.Day(), 0, 0, 0, 0, loc)
-		endLocal = time.Date(endParsed.Year(), endParsed.Month(), endParsed.Day(), 23, 59, 59, 0, loc).Add(time.Second)
-	case "date":
-		if dateStr == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Date parameter is required for 'date' period"})
-			return
-		}
-		parsedDate, err := time.ParseInLocation("2006-01-02", dateStr, loc)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date format. Use YYYY-MM-DD"})
-			return
-		}
-		startLocal = time.Date(parsedDate.Year(), parsedDate.Month(), parsedDate.Day(), 0, 0, 0, 0, loc)
-		endLocal = time.Date(parsedDate.Year(), parsedDate.Month(), parsedDate.Day(), 23, 59, 59, 0, loc).Add(time.Second)
-	case "today":
-		fallthrough
-	default:
-		startLocal = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
-		endLocal = time.Date(now.Year(), now.Month(), now.Day(), 23, 59, 59, 0, loc).Add(time.Second)
+	filters, err := parseLogFilters(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	startStr = startLocal.Format("2006-01-02T15:04:05")
-	endStr = endLocal.Format("2006-01-02T15:04:05")
+	page, err := parseLogPage(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	var logs []models.Log
+	startLocal, endLocal, err := parsePeriod(c, loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	query := config.DB.Where("timestamp >= ? AND timestamp <= ?", startStr, endStr)
+	query := config.DB.Model(&models.Log{}).Where("occurred_at >= ? AND occurred_at < ?", startLocal.UTC(), endLocal.UTC())
+	query = filters.apply(query).Session(&gorm.Session{})
 
-	if name != "" {
-		query = query.Where("name = ?", name)
+	logs, total, nextCursor, err := fetchLogPage(query, page)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
-	if err := query.Find(&logs).Error; err != nil {
+	var UnkVisitors int64
+	if err := query.Where("name = ?", "Unknown").Count(&UnkVisitors).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	var UnkVisitors int
-	for _, log := range logs {
-		if log.Name == "Unknown" {
-			UnkVisitors++
-		}
-	}
+	localizeLogs(logs, loc)
 
-	c.JSON(http.StatusOK, gin.H{"data": logs, "count": len(logs), "unknown_visitors": UnkVisitors})
+	c.JSON(http.StatusOK, gin.H{
+		"data":             logs,
+		"count":            total,
+		"unknown_visitors": UnkVisitors,
+		"timezone":         loc.String(),
+		"next_cursor":      nextCursor,
+		"has_more":         nextCursor != "",
+	})
 }
 
+// CreateLog validates and stores one log. An Idempotency-Key header or
+// event_id field makes retries safe: repeating a request returns the log that
+// was stored the first time with status 200 instead of 201.
 func CreateLog(c *gin.Context) {
-	var Log models.Log
-
-	if err := c.ShouldBindJSON(&Log); err != nil {
+	var input services.LogInput
+	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if err := config.DB.Create(&Log).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if key := strings.TrimSpace(c.GetHeader("Idempotency-Key")); key != "" {
+		if input.EventID != "" && input.EventID != key {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key header and event_id differ"})
+			return
+		}
+		input.EventID = key
+	}
+
+	Log, created, err := services.IngestLog(input)
+	if err != nil {
+		respondIngestError(c, err)
+		return
+	}
+
+	Log.OccurredAt = Log.OccurredAt.In(config.SiteLocation)
+	if !created {
+		c.JSON(http.StatusOK, gin.H{"data": Log, "duplicate": true})
 		return
 	}
 
 	go sendLogNotification(Log)
+	go services.CaptureEvidence(Log)
 
 	c.JSON(http.StatusCreated, gin.H{"data": Log})
 }
 
+func respondIngestError(c *gin.Context, err error) {
+	var validationErr *services.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid log", "details": validationErr.Fields})
+	case errors.Is(err, services.ErrIdempotencyConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
 func sendLogNotification(log models.Log) {
 	var users []models.User
 	if err := config.DB.Where("fcm_token != '' AND fcm_token IS NOT NULL").Find(&users).Error; err != nil {
@@ -142,11 +168,12 @@ func sendLogNotification(log models.Log) {
 	}
 
 	data := map[string]string{
-		"type":       "log",
-		"log_id":     fmt.Sprintf("%d", log.ID),
-		"name":       log.Name,
-		"authorized": fmt.Sprintf("%t", log.Authorized),
-		"timestamp":  log.Timestamp,
+		"type":        "log",
+		"log_id":      fmt.Sprintf("%d", log.ID),
+		"name":        log.Name,
+		"authorized":  fmt.Sprintf("%t", log.Authorized),
+		"timestamp":   log.Timestamp,
+		"occurred_at": log.OccurredAt.In(config.SiteLocation).Format(time.RFC3339),
 	}
 
 	var tokens []string
@@ -163,6 +190,8 @@ func sendLogNotification(log models.Log) {
 	}
 }
 
+// DeleteLog moves a log to the trash. It can be restored until a verificator
+// purges it.
 func DeleteLog(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
@@ -175,10 +204,106 @@ func DeleteLog(c *gin.Context) {
 		return
 	}
 
-	if err := config.DB.Unscoped().Delete(&models.Log{}, id).Error; err != nil {
+	if _, err := softDeleteLogs(byLogIDs([]uint{uint(id)}), c.GetString("username")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Log moved to trash"})
+}
+
+// GetTrashedLogs lists deleted logs with the same filters and pagination as
+// GetLogs.
+func GetTrashedLogs(c *gin.Context) {
+	loc, err := requestLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filters, err := parseLogFilters(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filters.Deleted = "only"
+
+	page, err := parseLogPage(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := filters.apply(config.DB.Model(&models.Log{})).Session(&gorm.Session{})
+
+	logs, total, nextCursor, err := fetchLogPage(query, page)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	localizeLogs(logs, loc)
+
+	c.JSON(http.StatusOK, gin.H{"data": logs, "count": total, "timezone": loc.String(), "next_cursor": nextCursor, "has_more": nextCursor != ""})
+}
+
+func RestoreLog(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	restored, err := restoreLogs(byLogIDs([]uint{uint(id)}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if restored == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Log not found in trash"})
+		return
+	}
+
+	var Log models.Log
+	if err := config.DB.First(&Log, id).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	Log.OccurredAt = Log.OccurredAt.In(config.SiteLocation)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Log restored successfully", "data": Log})
+}
+
+// PurgeLog permanently deletes a log that is already in the trash.
+func PurgeLog(c *gin.Context) {
+	if c.GetString("role") != "verificator" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	var Log models.Log
+	if err := config.DB.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).First(&Log).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Log not found in trash"})
+		return
+	}
+
+	if err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := services.RecordTombstones(tx, []models.Log{Log}, "purge"); err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&models.Log{}, Log.ID).Error
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	services.DeleteLogEvidence([]uint{Log.ID})
+	services.DeleteLogAnnotations([]uint{Log.ID})
 
-	c.JSON(http.StatusOK, gin.H{"message": "Log deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Log permanently deleted"})
 }
@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"comproBackend/config"
+	"comproBackend/models"
+	"comproBackend/services"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const exportBatchSize = 500
+
+var exportColumns = []services.ExportColumn{
+	{Title: "ID", Width: 50},
+	{Title: "Occurred At", Width: 150},
+	{Title: "Name", Width: 160},
+	{Title: "Role", Width: 80},
+	{Title: "Authorized", Width: 70},
+	{Title: "Confidence", Width: 70},
+	{Title: "Camera", Width: 95},
+	{Title: "Door", Width: 95},
+}
+
+// ExportLogs streams the logs matched by the /filter query parameters as CSV,
+// XLSX or PDF. Rows are read in keyset batches and written as they arrive.
+func ExportLogs(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	var contentType string
+	switch format {
+	case "csv":
+		contentType = "text/csv; charset=utf-8"
+	case "xlsx":
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case "pdf":
+		contentType = "application/pdf"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format. Use csv, xlsx or pdf"})
+		return
+	}
+
+	loc, err := requestLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filters, err := parseLogFilters(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	start, end, err := parsePeriod(c, loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// same sort as /filter; the whole range is exported, so limit and
+	// cursor are not taken from the query
+	page, err := parseLogPage(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	page.Limit, page.Cursor = exportBatchSize, nil
+
+	query := config.DB.Model(&models.Log{}).Where("occurred_at >= ? AND occurred_at < ?", start, end)
+	query = filters.apply(query).Session(&gorm.Session{})
+
+	audit := models.LogExport{
+		UserID:     c.GetUint("user_id"),
+		Username:   c.GetString("username"),
+		Format:     format,
+		Query:      c.Request.URL.RawQuery,
+		RangeStart: start,
+		RangeEnd:   end,
+		Status:     "started",
+	}
+	if err := config.DB.Create(&audit).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record export"})
+		return
+	}
+
+	var report services.PDFReport
+	if format == "pdf" {
+		summary, err := queryStatsSummary(query)
+		if err != nil {
+			finishExport(&audit, 0, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		report = services.PDFReport{
+			Title: "Access Log Report",
+			Lines: []string{
+				fmt.Sprintf("Period: %s - %s (%s)", start.In(loc).Format("2006-01-02 15:04"), end.In(loc).Format("2006-01-02 15:04"), loc),
+				fmt.Sprintf("Generated: %s by %s", time.Now().In(loc).Format("2006-01-02 15:04:05"), audit.Username),
+			},
+			Summary: []string{
+				fmt.Sprintf("Total entries: %d", summary.Total),
+				fmt.Sprintf("Authorized: %d   Unauthorized: %d", summary.Authorized, summary.Unauthorized),
+				fmt.Sprintf("Unknown visitors: %d (%.1f%%)", summary.UnknownVisitors, summary.UnknownRate*100),
+				fmt.Sprintf("Average confidence: %.2f", summary.AverageConfidence),
+			},
+		}
+	}
+
+	filename := fmt.Sprintf("logs_%s_%s.%s", start.In(loc).Format("20060102"), end.Add(-time.Second).In(loc).Format("20060102"), format)
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Status(http.StatusOK)
+
+	var writer services.TableWriter
+	switch format {
+	case "csv":
+		writer, err = services.NewCSVTableWriter(c.Writer, exportColumns)
+	case "xlsx":
+		writer, err = services.NewXLSXTableWriter(c.Writer, "Logs", exportColumns)
+	case "pdf":
+		writer, err = services.NewPDFTableWriter(c.Writer, report, exportColumns)
+	}
+	if err != nil {
+		finishExport(&audit, 0, err)
+		return
+	}
+
+	var rows int64
+	for {
+		var batch []models.Log
+		if err := page.apply(query).Find(&batch).Error; err != nil {
+			finishExport(&audit, rows, err)
+			return
+		}
+
+		more := len(batch) > page.Limit
+		if more {
+			batch = batch[:page.Limit]
+		}
+
+		for _, l := range batch {
+			if err := writer.WriteRow([]interface{}{
+				l.ID,
+				l.OccurredAt.In(loc),
+				l.Name,
+				l.Role,
+				l.Authorized,
+				l.Confidence,
+				l.CameraID,
+				l.Door,
+			}); err != nil {
+				finishExport(&audit, rows, err)
+				return
+			}
+			rows++
+		}
+
+		if !more {
+			break
+		}
+		last := batch[len(batch)-1]
+		page.Cursor = &logCursor{OccurredAt: last.OccurredAt, ID: last.ID}
+	}
+
+	finishExport(&audit, rows, writer.Close())
+}
+
+func finishExport(audit *models.LogExport, rows int64, err error) {
+	now := time.Now()
+	audit.RowCount = rows
+	audit.FinishedAt = &now
+	audit.Status = "completed"
+	if err != nil {
+		log.Printf("Log export %d failed: %v", audit.ID, err)
+		audit.Status = "failed"
+		audit.Error = err.Error()
+	}
+	if err := config.DB.Save(audit).Error; err != nil {
+		log.Printf("Failed to update log export %d: %v", audit.ID, err)
+	}
+}
+
+// GetLogExports lists the export audit trail, newest first.
+func GetLogExports(c *gin.Context) {
+	if c.GetString("role") != "verificator" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	var exports []models.LogExport
+	if err := config.DB.Order("id DESC").Limit(200).Find(&exports).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": exports})
+}
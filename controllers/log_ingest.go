@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"comproBackend/config"
+	"comproBackend/models"
+	"comproBackend/services"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxBatchLogs = 500
+
+// batchLogResult is the outcome for one item of a batch, in request order.
+type batchLogResult struct {
+	Index   int                   `json:"index"`
+	Status  string                `json:"status"` // created | duplicate | invalid | conflict | failed
+	EventID string                `json:"event_id,omitempty"`
+	Log     *models.Log           `json:"data,omitempty"`
+	Error   string                `json:"error,omitempty"`
+	Details []services.FieldError `json:"details,omitempty"`
+}
+
+// CreateLogBatch stores up to maxBatchLogs logs, each on its own, and reports
+// a result per item. It is meant for replaying events that queued up while
+// the backend was unreachable, so no push notifications or evidence are
+// produced.
+func CreateLogBatch(c *gin.Context) {
+	var input struct {
+		Logs []services.LogInput `json:"logs"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(input.Logs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "logs must not be empty"})
+		return
+	}
+	if len(input.Logs) > maxBatchLogs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Too many logs, send at most %d per batch", maxBatchLogs)})
+		return
+	}
+
+	results := make([]batchLogResult, len(input.Logs))
+	counts := map[string]int{}
+	for i, item := range input.Logs {
+		result := batchLogResult{Index: i, EventID: item.EventID}
+
+		Log, created, err := services.IngestLog(item)
+		var validationErr *services.ValidationError
+		switch {
+		case err == nil && created:
+			result.Status = "created"
+		case err == nil:
+			result.Status = "duplicate"
+		case errors.As(err, &validationErr):
+			result.Status = "invalid"
+			result.Details = validationErr.Fields
+		case errors.Is(err, services.ErrIdempotencyConflict):
+			result.Status = "conflict"
+		default:
+			result.Status = "failed"
+		}
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			Log.OccurredAt = Log.OccurredAt.In(config.SiteLocation)
+			result.Log = &Log
+		}
+
+		results[i] = result
+		counts[result.Status]++
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results, "counts": counts})
+}
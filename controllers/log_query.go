@@ -0,0 +1,377 @@
+package controllers
+
+import (
+	"comproBackend/config"
+	"comproBackend/models"
+	"comproBackend/utils"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultLogPageSize = 50
+	maxLogPageSize     = 500
+)
+
+// logFilters holds the optional filters shared by the log listing endpoints.
+type logFilters struct {
+	Name          string
+	NamePrefix    string
+	Roles         []string
+	Authorized    *bool
+	MinConfidence *float64
+	MaxConfidence *float64
+	UnknownOnly   bool
+	CameraID      string
+	Door          string
+	From          *time.Time
+	To            *time.Time
+	Deleted       string // exclude (default), include or only
+	ReviewStatus  []string
+	Tags          []string // logs carrying any of them
+}
+
+// logPage describes the requested page: its size, sort direction and the
+// cursor returned by the previous page.
+type logPage struct {
+	Limit  int
+	Desc   bool
+	Cursor *logCursor
+}
+
+// logCursor is the position of the last row of a page in (occurred_at, id)
+// order.
+type logCursor struct {
+	OccurredAt time.Time
+	ID         uint
+}
+
+func parseLogFilters(c *gin.Context) (logFilters, error) {
+	f := logFilters{
+		Name:       c.Query("name"),
+		NamePrefix: c.Query("name_prefix"),
+		CameraID:   c.Query("camera"),
+		Door:       c.Query("door"),
+		Deleted:    c.DefaultQuery("deleted", "exclude"),
+	}
+
+	switch f.Deleted {
+	case "exclude", "include", "only":
+	default:
+		return f, errors.New("Invalid deleted value. Use exclude, include or only")
+	}
+
+	if roles := c.Query("role"); roles != "" {
+		for _, role := range strings.Split(roles, ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				f.Roles = append(f.Roles, role)
+			}
+		}
+	}
+
+	if statuses := c.Query("review_status"); statuses != "" {
+		for _, status := range strings.Split(statuses, ",") {
+			status = strings.TrimSpace(status)
+			if !slices.Contains(models.ReviewStatuses, status) {
+				return f, errors.New("Invalid review_status value. Use " + strings.Join(models.ReviewStatuses, ", "))
+			}
+			f.ReviewStatus = append(f.ReviewStatus, status)
+		}
+	}
+
+	if tags := c.Query("tag"); tags != "" {
+		for _, tag := range strings.Split(tags, ",") {
+			if tag = strings.Join(strings.Fields(strings.ToLower(tag)), " "); tag != "" {
+				f.Tags = append(f.Tags, tag)
+			}
+		}
+	}
+
+	if v := c.Query("authorized"); v != "" {
+		authorized, err := strconv.ParseBool(v)
+		if err != nil {
+			return f, errors.New("Invalid authorized value. Use true or false")
+		}
+		f.Authorized = &authorized
+	}
+
+	if v := c.Query("min_confidence"); v != "" {
+		minConf, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return f, errors.New("Invalid min_confidence value")
+		}
+		f.MinConfidence = &minConf
+	}
+
+	if v := c.Query("max_confidence"); v != "" {
+		maxConf, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return f, errors.New("Invalid max_confidence value")
+		}
+		f.MaxConfidence = &maxConf
+	}
+
+	if f.MinConfidence != nil && f.MaxConfidence != nil && *f.MinConfidence > *f.MaxConfidence {
+		return f, errors.New("min_confidence must not be greater than max_confidence")
+	}
+
+	from, err := parseTimeParam(c, "from")
+	if err != nil {
+		return f, err
+	}
+	f.From = from
+
+	to, err := parseTimeParam(c, "to")
+	if err != nil {
+		return f, err
+	}
+	f.To = to
+
+	if v := c.Query("unknown_only"); v != "" {
+		unknownOnly, err := strconv.ParseBool(v)
+		if err != nil {
+			return f, errors.New("Invalid unknown_only value. Use true or false")
+		}
+		f.UnknownOnly = unknownOnly
+	}
+
+	return f, nil
+}
+
+func (f logFilters) apply(query *gorm.DB) *gorm.DB {
+	switch f.Deleted {
+	case "include":
+		query = query.Unscoped()
+	case "only":
+		query = query.Unscoped().Where("deleted_at IS NOT NULL")
+	}
+	if f.Name != "" {
+		query = query.Where("name = ?", f.Name)
+	}
+	if f.NamePrefix != "" {
+		query = query.Where("name LIKE ?", escapeLike(f.NamePrefix)+"%")
+	}
+	if len(f.Roles) > 0 {
+		query = query.Where("role IN ?", f.Roles)
+	}
+	if f.Authorized != nil {
+		query = query.Where("authorized = ?", *f.Authorized)
+	}
+	if f.MinConfidence != nil {
+		query = query.Where("confidence >= ?", *f.MinConfidence)
+	}
+	if f.MaxConfidence != nil {
+		query = query.Where("confidence <= ?", *f.MaxConfidence)
+	}
+	if f.UnknownOnly {
+		query = query.Where("name = ?", "Unknown")
+	}
+	if f.CameraID != "" {
+		query = query.Where("camera_id = ?", f.CameraID)
+	}
+	if f.Door != "" {
+		query = query.Where("door = ?", f.Door)
+	}
+	if f.From != nil {
+		query = query.Where("occurred_at >= ?", *f.From)
+	}
+	if f.To != nil {
+		query = query.Where("occurred_at < ?", *f.To)
+	}
+	if len(f.ReviewStatus) > 0 {
+		query = query.Where("review_status IN ?", f.ReviewStatus)
+	}
+	if len(f.Tags) > 0 {
+		query = query.Where("id IN (?)", config.DB.Model(&models.LogTag{}).Select("log_id").Where("tag IN ?", f.Tags))
+	}
+	return query
+}
+
+func parseLogPage(c *gin.Context) (logPage, error) {
+	p := logPage{Limit: defaultLogPageSize, Desc: true}
+
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return p, errors.New("Invalid limit value")
+		}
+		if limit > maxLogPageSize {
+			limit = maxLogPageSize
+		}
+		p.Limit = limit
+	}
+
+	switch strings.ToLower(c.DefaultQuery("sort", "desc")) {
+	case "desc":
+		p.Desc = true
+	case "asc":
+		p.Desc = false
+	default:
+		return p, errors.New("Invalid sort value. Use asc or desc")
+	}
+
+	if v := c.Query("cursor"); v != "" {
+		cursor, err := decodeLogCursor(v)
+		if err != nil {
+			return p, errors.New("Invalid cursor")
+		}
+		p.Cursor = &cursor
+	}
+
+	return p, nil
+}
+
+// apply restricts the query to the rows after the cursor and orders it. One
+// extra row is fetched so the caller can tell whether another page exists.
+func (p logPage) apply(query *gorm.DB) *gorm.DB {
+	op, dir := ">", "ASC"
+	if p.Desc {
+		op, dir = "<", "DESC"
+	}
+	if p.Cursor != nil {
+		query = query.Where("(occurred_at "+op+" ?) OR (occurred_at = ? AND id "+op+" ?)",
+			p.Cursor.OccurredAt, p.Cursor.OccurredAt, p.Cursor.ID)
+	}
+	return query.Order("occurred_at " + dir).Order("id " + dir).Limit(p.Limit + 1)
+}
+
+func encodeLogCursor(l models.Log) string {
+	raw := strconv.FormatInt(l.OccurredAt.UnixMilli(), 10) + ":" + strconv.FormatUint(uint64(l.ID), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeLogCursor(cursor string) (logCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return logCursor{}, err
+	}
+	millis, idStr, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return logCursor{}, errors.New("malformed cursor")
+	}
+	ms, err := strconv.ParseInt(millis, 10, 64)
+	if err != nil {
+		return logCursor{}, err
+	}
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		return logCursor{}, err
+	}
+	return logCursor{OccurredAt: time.UnixMilli(ms).UTC(), ID: uint(id)}, nil
+}
+
+// parseTimeParam reads an optional RFC3339 query parameter as a UTC instant.
+func parseTimeParam(c *gin.Context, name string) (*time.Time, error) {
+	v := c.Query(name)
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, errors.New("Invalid " + name + " value. Use RFC3339, e.g. 2025-12-18T07:30:00+07:00")
+	}
+	t = t.UTC()
+	return &t, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// fetchLogPage counts every row matched by query and loads a single page of
+// it. query must be a reusable session so the count and the page load do not
+// share conditions.
+func fetchLogPage(query *gorm.DB, page logPage) ([]models.Log, int64, string, error) {
+	var total int64
+	if err := query.Model(&models.Log{}).Count(&total).Error; err != nil {
+		return nil, 0, "", err
+	}
+
+	var logs []models.Log
+	if err := page.apply(query).Find(&logs).Error; err != nil {
+		return nil, 0, "", err
+	}
+
+	var nextCursor string
+	if len(logs) > page.Limit {
+		logs = logs[:page.Limit]
+		nextCursor = encodeLogCursor(logs[len(logs)-1])
+	}
+
+	if err := loadLogTags(logs); err != nil {
+		return nil, 0, "", err
+	}
+
+	return logs, total, nextCursor, nil
+}
+
+// requestLocation returns the timezone named by the tz query parameter, or
+// the site timezone when it is absent.
+func requestLocation(c *gin.Context) (*time.Location, error) {
+	name := c.Query("tz")
+	if name == "" {
+		return config.SiteLocation, nil
+	}
+	loc, err := config.LoadLocation(name)
+	if err != nil {
+		return nil, errors.New("Invalid tz value. Use an IANA timezone name, e.g. Asia/Jakarta")
+	}
+	return loc, nil
+}
+
+// parsePeriod resolves the period, date, start and end query parameters into
+// a half-open [start, end) interval of local days in loc.
+func parsePeriod(c *gin.Context, loc *time.Location) (time.Time, time.Time, error) {
+	switch c.Query("period") {
+	case "range":
+		startDateStr, endDateStr := c.Query("start"), c.Query("end")
+		if startDateStr == "" || endDateStr == "" {
+			return time.Time{}, time.Time{}, errors.New("Start and end date parameters are required for 'range' period")
+		}
+		startParsed, err := time.Parse("2006-01-02", startDateStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("Invalid start date format. Use YYYY-MM-DD")
+		}
+		endParsed, err := time.Parse("2006-01-02", endDateStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("Invalid end date format. Use YYYY-MM-DD")
+		}
+		if endParsed.Before(startParsed) {
+			return time.Time{}, time.Time{}, errors.New("End date must not be before start date")
+		}
+		start, end := utils.DayRange(startParsed, endParsed, loc)
+		return start, end, nil
+	case "date":
+		dateStr := c.Query("date")
+		if dateStr == "" {
+			return time.Time{}, time.Time{}, errors.New("Date parameter is required for 'date' period")
+		}
+		parsedDate, err := time.Parse("2006-01-02", dateStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("Invalid date format. Use YYYY-MM-DD")
+		}
+		start, end := utils.DayRange(parsedDate, parsedDate, loc)
+		return start, end, nil
+	case "today":
+		fallthrough
+	default:
+		now := time.Now().In(loc)
+		start, end := utils.DayRange(now, now, loc)
+		return start, end, nil
+	}
+}
+
+// localizeLogs converts OccurredAt to loc so it is serialized with that
+// zone's UTC offset instead of Z.
+func localizeLogs(logs []models.Log, loc *time.Location) {
+	for i := range logs {
+		logs[i].OccurredAt = logs[i].OccurredAt.In(loc)
+	}
+}
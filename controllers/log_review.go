@@ -0,0 +1,293 @@
+package controllers
+
+import (
+	"comproBackend/config"
+	"comproBackend/events"
+	"comproBackend/models"
+	"comproBackend/services"
+	"comproBackend/utils"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	maxLogTags     = 20
+	maxLogTagLen   = 50
+	maxLogNoteSize = 4000
+)
+
+var logTagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9 _-]*$`)
+
+// ReviewLog sets the review status of a log and records who changed it. An
+// optional note is stored in the same request.
+func ReviewLog(c *gin.Context) {
+	var input struct {
+		Status string `json:"status" binding:"required"`
+		Note   string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !slices.Contains(models.ReviewStatuses, input.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status. Use " + strings.Join(models.ReviewStatuses, ", ")})
+		return
+	}
+	input.Note = strings.TrimSpace(input.Note)
+	if len(input.Note) > maxLogNoteSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Note is too long"})
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	var Log models.Log
+	if err := config.DB.First(&Log, uint(id)).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Log not found"})
+		return
+	}
+
+	username := c.GetString("username")
+	updates := map[string]interface{}{"review_status": input.Status, "reviewed_by": username, "reviewed_at": time.Now().UTC()}
+	if input.Status == models.ReviewUnreviewed {
+		updates = map[string]interface{}{"review_status": input.Status, "reviewed_by": "", "reviewed_at": nil}
+	}
+
+	var note *models.LogNote
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Log).Updates(updates).Error; err != nil {
+			return err
+		}
+		if input.Note != "" {
+			note = &models.LogNote{LogID: Log.ID, Username: username, Body: input.Note}
+			return tx.Create(note).Error
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := config.DB.First(&Log, Log.ID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	logs := []models.Log{Log}
+	if err := loadLogTags(logs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	Log = logs[0]
+
+	utils.Publish(events.New(events.TypeLogReview, events.SourceBackend, events.LogReviewPayload{
+		LogID:        Log.ID,
+		ReviewStatus: Log.ReviewStatus,
+		ReviewedBy:   Log.ReviewedBy,
+		ReviewedAt:   Log.ReviewedAt,
+		Note:         note,
+	}))
+	services.PublishLogEvent(services.LogEvent{Type: services.LogEventReviewed, Log: Log})
+
+	Log.OccurredAt = Log.OccurredAt.In(config.SiteLocation)
+	c.JSON(http.StatusOK, gin.H{"message": "Review status updated", "data": Log, "note": note})
+}
+
+// GetLogNotes lists the notes of a log, oldest first.
+func GetLogNotes(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	var Log models.Log
+	if err := config.DB.First(&Log, uint(id)).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Log not found"})
+		return
+	}
+
+	var notes []models.LogNote
+	if err := config.DB.Where("log_id = ?", Log.ID).Order("id").Find(&notes).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": notes})
+}
+
+// AddLogNote attaches a note to a log.
+func AddLogNote(c *gin.Context) {
+	var input struct {
+		Body string `json:"body" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	input.Body = strings.TrimSpace(input.Body)
+	if input.Body == "" || len(input.Body) > maxLogNoteSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Note must not be empty or longer than %d characters", maxLogNoteSize)})
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	var Log models.Log
+	if err := config.DB.First(&Log, uint(id)).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Log not found"})
+		return
+	}
+
+	note := models.LogNote{LogID: Log.ID, Username: c.GetString("username"), Body: input.Body}
+	if err := config.DB.Create(&note).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	utils.Publish(events.New(events.TypeLogNote, events.SourceBackend, note))
+
+	c.JSON(http.StatusCreated, gin.H{"data": note})
+}
+
+// SetLogTags replaces the tags of a log.
+func SetLogTags(c *gin.Context) {
+	var input struct {
+		Tags []string `json:"tags"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tags, err := normalizeLogTags(input.Tags)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	var Log models.Log
+	if err := config.DB.First(&Log, uint(id)).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Log not found"})
+		return
+	}
+
+	username := c.GetString("username")
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		removed := tx.Where("log_id = ?", Log.ID)
+		if len(tags) > 0 {
+			removed = removed.Where("tag NOT IN ?", tags)
+		}
+		if err := removed.Delete(&models.LogTag{}).Error; err != nil {
+			return err
+		}
+
+		var existing []string
+		if err := tx.Model(&models.LogTag{}).Where("log_id = ?", Log.ID).Pluck("tag", &existing).Error; err != nil {
+			return err
+		}
+		for _, tag := range tags {
+			if slices.Contains(existing, tag) {
+				continue
+			}
+			if err := tx.Create(&models.LogTag{LogID: Log.ID, Tag: tag, CreatedBy: username}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	utils.Publish(events.New(events.TypeLogTags, events.SourceBackend, events.LogTagsPayload{LogID: Log.ID, Tags: tags, UpdatedBy: username}))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tags updated", "data": tags})
+}
+
+// GetLogTags lists every tag in use with the number of logs carrying it.
+func GetLogTags(c *gin.Context) {
+	var tags []struct {
+		Tag   string `json:"tag"`
+		Count int64  `json:"count"`
+	}
+	if err := config.DB.Model(&models.LogTag{}).
+		Select("tag, COUNT(*) AS count").
+		Group("tag").Order("count DESC").Order("tag").
+		Scan(&tags).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": tags})
+}
+
+// normalizeLogTags lower cases, trims and deduplicates tags and returns them
+// sorted.
+func normalizeLogTags(raw []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var tags []string
+	for _, tag := range raw {
+		tag = strings.Join(strings.Fields(strings.ToLower(tag)), " ")
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > maxLogTagLen || !logTagPattern.MatchString(tag) {
+			return nil, fmt.Errorf("Invalid tag %q. Use letters, digits, space, - and _, at most %d characters", tag, maxLogTagLen)
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	if len(tags) > maxLogTags {
+		return nil, fmt.Errorf("Too many tags, at most %d per log", maxLogTags)
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// loadLogTags fills the Tags field of logs.
+func loadLogTags(logs []models.Log) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(logs))
+	for i, l := range logs {
+		ids[i] = l.ID
+	}
+
+	var tags []models.LogTag
+	if err := config.DB.Where("log_id IN ?", ids).Order("tag").Find(&tags).Error; err != nil {
+		return err
+	}
+	byLog := make(map[uint][]string)
+	for _, t := range tags {
+		byLog[t.LogID] = append(byLog[t.LogID], t.Tag)
+	}
+	for i := range logs {
+		logs[i].Tags = byLog[logs[i].ID]
+	}
+	return nil
+}
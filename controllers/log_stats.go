@@ -0,0 +1,279 @@
+package controllers
+
+import (
+	"comproBackend/config"
+	"comproBackend/models"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type hourStat struct {
+	Hour  int   `json:"hour"`
+	Count int64 `json:"count"`
+}
+
+type weekdayStat struct {
+	Weekday int    `json:"weekday"`
+	Day     string `json:"day"`
+	Count   int64  `json:"count"`
+}
+
+type personStat struct {
+	Name              string  `json:"name"`
+	Count             int64   `json:"count"`
+	Authorized        int64   `json:"authorized"`
+	AverageConfidence float64 `json:"average_confidence"`
+}
+
+type roleStat struct {
+	Role  string `json:"role"`
+	Count int64  `json:"count"`
+}
+
+type dailyStat struct {
+	Date              string  `json:"date"`
+	Count             int64   `json:"count"`
+	UnknownVisitors   int64   `json:"unknown_visitors"`
+	UnknownRate       float64 `json:"unknown_rate"`
+	AverageConfidence float64 `json:"average_confidence"`
+}
+
+type presenceStat struct {
+	Date       string    `json:"date"`
+	Name       string    `json:"name"`
+	FirstEntry time.Time `json:"first_entry"`
+	LastEntry  time.Time `json:"last_entry"`
+	Count      int64     `json:"count"`
+}
+
+type statsSummary struct {
+	Total             int64   `json:"total"`
+	Authorized        int64   `json:"authorized"`
+	Unauthorized      int64   `json:"unauthorized"`
+	UnknownVisitors   int64   `json:"unknown_visitors"`
+	UnknownRate       float64 `json:"unknown_rate"`
+	AverageConfidence float64 `json:"average_confidence"`
+}
+
+// GetLogStats aggregates the logs of a period in SQL. Hour, weekday and day
+// buckets are computed in the requested timezone.
+func GetLogStats(c *gin.Context) {
+	loc, err := requestLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filters, err := parseLogFilters(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	start, end, err := parsePeriod(c, loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// from/to narrow or replace the period bounds
+	if filters.From != nil {
+		start = *filters.From
+	}
+	if filters.To != nil {
+		end = *filters.To
+	}
+	if !start.Before(end) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start of the period must be before its end"})
+		return
+	}
+
+	base := config.DB.Model(&models.Log{}).Where("occurred_at >= ? AND occurred_at < ?", start, end)
+	base = filters.apply(base).Session(&gorm.Session{})
+
+	localExpr, localArgs := localTimeExpr(start, end, loc)
+
+	summary, err := queryStatsSummary(base)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var hourRows []hourStat
+	if err := base.Select("HOUR("+localExpr+") AS hour, COUNT(*) AS count", localArgs...).
+		Group("hour").Scan(&hourRows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	byHour := make([]hourStat, 24)
+	for h := range byHour {
+		byHour[h].Hour = h
+	}
+	for _, row := range hourRows {
+		if row.Hour >= 0 && row.Hour < 24 {
+			byHour[row.Hour].Count = row.Count
+		}
+	}
+
+	// DAYOFWEEK is 1 for Sunday; shift to match time.Weekday
+	var weekdayRows []weekdayStat
+	if err := base.Select("DAYOFWEEK("+localExpr+") - 1 AS weekday, COUNT(*) AS count", localArgs...).
+		Group("weekday").Scan(&weekdayRows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	byWeekday := make([]weekdayStat, 7)
+	for d := range byWeekday {
+		byWeekday[d].Weekday = d
+		byWeekday[d].Day = time.Weekday(d).String()
+	}
+	for _, row := range weekdayRows {
+		if row.Weekday >= 0 && row.Weekday < 7 {
+			byWeekday[row.Weekday].Count = row.Count
+		}
+	}
+
+	var byPerson []personStat
+	if err := base.Select("name, COUNT(*) AS count, COALESCE(SUM(authorized), 0) AS authorized, AVG(confidence) AS average_confidence").
+		Group("name").Order("count DESC").Scan(&byPerson).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var byRole []roleStat
+	if err := base.Select("role, COUNT(*) AS count").
+		Group("role").Order("count DESC").Scan(&byRole).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var dailyRows []struct {
+		Day               time.Time
+		Count             int64
+		UnknownVisitors   int64
+		AverageConfidence float64
+	}
+	if err := base.Select(
+		"DATE("+localExpr+") AS day, COUNT(*) AS count, "+
+			"COALESCE(SUM(name = 'Unknown'), 0) AS unknown_visitors, AVG(confidence) AS average_confidence",
+		localArgs...,
+	).Group("day").Order("day").Scan(&dailyRows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	daily := make([]dailyStat, 0, len(dailyRows))
+	for _, row := range dailyRows {
+		daily = append(daily, dailyStat{
+			Date:              row.Day.Format("2006-01-02"),
+			Count:             row.Count,
+			UnknownVisitors:   row.UnknownVisitors,
+			UnknownRate:       rate(row.UnknownVisitors, row.Count),
+			AverageConfidence: row.AverageConfidence,
+		})
+	}
+
+	var presenceRows []struct {
+		Day        time.Time
+		Name       string
+		FirstEntry time.Time
+		LastEntry  time.Time
+		Count      int64
+	}
+	if err := base.Where("name <> ?", "Unknown").Select(
+		"DATE("+localExpr+") AS day, name, MIN(occurred_at) AS first_entry, MAX(occurred_at) AS last_entry, COUNT(*) AS count",
+		localArgs...,
+	).Group("day").Group("name").Order("day").Order("name").Scan(&presenceRows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	presence := make([]presenceStat, 0, len(presenceRows))
+	for _, row := range presenceRows {
+		presence = append(presence, presenceStat{
+			Date:       row.Day.Format("2006-01-02"),
+			Name:       row.Name,
+			FirstEntry: row.FirstEntry.In(loc),
+			LastEntry:  row.LastEntry.In(loc),
+			Count:      row.Count,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"timezone":   loc.String(),
+		"start":      start.In(loc),
+		"end":        end.In(loc),
+		"summary":    summary,
+		"by_hour":    byHour,
+		"by_weekday": byWeekday,
+		"by_person":  byPerson,
+		"by_role":    byRole,
+		"daily":      daily,
+		"presence":   presence,
+	})
+}
+
+// queryStatsSummary computes the headline counters over every row matched by
+// base.
+func queryStatsSummary(base *gorm.DB) (statsSummary, error) {
+	var summary statsSummary
+	if err := base.Select(
+		"COUNT(*) AS total, " +
+			"COALESCE(SUM(authorized), 0) AS authorized, " +
+			"COALESCE(SUM(name = 'Unknown'), 0) AS unknown_visitors, " +
+			"COALESCE(AVG(confidence), 0) AS average_confidence",
+	).Scan(&summary).Error; err != nil {
+		return summary, err
+	}
+	summary.Unauthorized = summary.Total - summary.Authorized
+	summary.UnknownRate = rate(summary.UnknownVisitors, summary.Total)
+	return summary, nil
+}
+
+// localTimeExpr returns an SQL expression converting the UTC occurred_at
+// column to wall-clock time in loc. It does not rely on the MySQL timezone
+// tables: the period is split at every DST transition and each segment gets
+// its own fixed offset.
+func localTimeExpr(start, end time.Time, loc *time.Location) (string, []interface{}) {
+	type segment struct {
+		until  time.Time
+		offset int
+	}
+
+	var segments []segment
+	for t := start; t.Before(end); {
+		local := t.In(loc)
+		_, offset := local.Zone()
+		_, zoneEnd := local.ZoneBounds()
+		if zoneEnd.IsZero() || zoneEnd.After(end) {
+			zoneEnd = end
+		}
+		segments = append(segments, segment{until: zoneEnd, offset: offset})
+		t = zoneEnd
+	}
+
+	if len(segments) <= 1 {
+		_, offset := start.In(loc).Zone()
+		return "DATE_ADD(occurred_at, INTERVAL ? SECOND)", []interface{}{offset}
+	}
+
+	var sb strings.Builder
+	var args []interface{}
+	sb.WriteString("DATE_ADD(occurred_at, INTERVAL CASE")
+	for _, seg := range segments[:len(segments)-1] {
+		sb.WriteString(" WHEN occurred_at < ? THEN ?")
+		args = append(args, seg.until.UTC(), seg.offset)
+	}
+	sb.WriteString(" ELSE ? END SECOND)")
+	args = append(args, segments[len(segments)-1].offset)
+	return sb.String(), args
+}
+
+func rate(part, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total)
+}
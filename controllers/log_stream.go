@@ -0,0 +1,324 @@
+package controllers
+
+import (
+	"comproBackend/config"
+	"comproBackend/models"
+	"comproBackend/services"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	streamBuffer      = 256
+	streamHeartbeat   = 25 * time.Second
+	streamReplayBatch = 500
+	streamRetryMillis = 3000
+	streamGapWait     = 2 * time.Second
+	streamGapPoll     = 250 * time.Millisecond
+)
+
+// streamCursor is how far a stream client has read: the last created log and
+// the last review in (reviewed_at, id) order. It is sent as the SSE event ID.
+type streamCursor struct {
+	LogID    uint
+	ReviewMs int64
+	ReviewID uint
+}
+
+func (s streamCursor) String() string {
+	return fmt.Sprintf("%d-%d-%d", s.LogID, s.ReviewMs, s.ReviewID)
+}
+
+func parseStreamCursor(value string) (streamCursor, error) {
+	parts := strings.Split(value, "-")
+	if len(parts) != 3 {
+		return streamCursor{}, errors.New("Invalid Last-Event-ID")
+	}
+	logID, err1 := strconv.ParseUint(parts[0], 10, 32)
+	reviewMs, err2 := strconv.ParseInt(parts[1], 10, 64)
+	reviewID, err3 := strconv.ParseUint(parts[2], 10, 32)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return streamCursor{}, errors.New("Invalid Last-Event-ID")
+	}
+	return streamCursor{LogID: uint(logID), ReviewMs: reviewMs, ReviewID: uint(reviewID)}, nil
+}
+
+// after reports whether a review of log id at reviewedAt is newer than the
+// cursor.
+func (s streamCursor) after(reviewedAt time.Time, id uint) bool {
+	ms := reviewedAt.UnixMilli()
+	return ms > s.ReviewMs || ms == s.ReviewMs && id > s.ReviewID
+}
+
+// StreamLogs is a Server-Sent Events feed of new logs ("log" events) and
+// review changes ("log_review" events). It takes the /filter query; period is
+// only applied when given. A client reconnecting with Last-Event-ID (or the
+// last_event_id query parameter) first receives what it missed from the
+// database.
+//
+// New logs are always read from the database in ID order; a live event only
+// triggers the read. IDs are assigned at insert but logs become visible at
+// commit, so a missing ID may still show up. Like the database WebSocket
+// backplane, the stream waits for it until streamGapWait after the next log
+// was created before moving the cursor past it.
+func StreamLogs(c *gin.Context) {
+	loc, err := requestLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filters, err := parseLogFilters(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := filters.apply(config.DB.Model(&models.Log{}))
+	if c.Query("period") != "" {
+		startLocal, endLocal, err := parsePeriod(c, loc)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		query = query.Where("occurred_at >= ? AND occurred_at < ?", startLocal.UTC(), endLocal.UTC())
+	}
+	query = query.Session(&gorm.Session{})
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	var cursor streamCursor
+	if lastEventID != "" {
+		if cursor, err = parseStreamCursor(lastEventID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// subscribe before reading the database so nothing falls in between
+	events, unsubscribe := services.SubscribeLogEvents(streamBuffer)
+	defer unsubscribe()
+
+	// logs that existed before a new client connected are not sent to it
+	var skip map[uint]bool
+	if lastEventID == "" {
+		if cursor.LogID, skip, err = streamStart(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		cursor.ReviewMs = time.Now().UnixMilli()
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	send := func(event string, data interface{}) bool {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return false
+		}
+		if _, err := fmt.Fprintf(c.Writer, "id: %s\nevent: %s\ndata: %s\n\n", cursor, event, payload); err != nil {
+			return false
+		}
+		c.Writer.Flush()
+		return true
+	}
+	sendLog := func(event string, l models.Log) bool {
+		l.OccurredAt = l.OccurredAt.In(loc)
+		return send(event, l)
+	}
+
+	fmt.Fprintf(c.Writer, "retry: %d\n\n", streamRetryMillis)
+	seenUpTo := cursor.LogID
+	replayed, gap, ok := sendNewLogs(query, &cursor, skip, sendLog)
+	if !ok {
+		return
+	}
+	if lastEventID != "" {
+		reviews, ok := replayLogReviews(query, &cursor, seenUpTo, sendLog)
+		if !ok {
+			return
+		}
+		replayed += reviews
+	}
+	if !send("ready", gin.H{"replayed": replayed}) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	var gapPoll <-chan time.Time
+	for {
+		if gap && gapPoll == nil {
+			gapPoll = time.After(streamGapPoll)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case <-gapPoll:
+			gapPoll = nil
+			if _, gap, ok = sendNewLogs(query, &cursor, skip, sendLog); !ok {
+				return
+			}
+		case e, ok := <-events:
+			if !ok {
+				// fell too far behind; the client reconnects with its
+				// Last-Event-ID and catches up from the database
+				return
+			}
+
+			l := e.Log
+			switch e.Type {
+			case services.LogEventCreated:
+				if _, gap, ok = sendNewLogs(query, &cursor, skip, sendLog); !ok {
+					return
+				}
+				continue
+			case services.LogEventReviewed:
+				if l.ReviewedAt != nil {
+					if !cursor.after(*l.ReviewedAt, l.ID) {
+						continue
+					}
+					cursor.ReviewMs, cursor.ReviewID = l.ReviewedAt.UnixMilli(), l.ID
+				}
+			default:
+				continue
+			}
+
+			var matches int64
+			if err := query.Where("id = ?", l.ID).Count(&matches).Error; err != nil || matches == 0 {
+				continue
+			}
+			if !sendLog(e.Type, l) {
+				return
+			}
+		}
+	}
+}
+
+// streamStart returns where a new client starts reading: the last log whose
+// predecessors are all settled, and the logs after it that already exist and
+// must be skipped.
+func streamStart() (uint, map[uint]bool, error) {
+	var start uint
+	if err := config.DB.Unscoped().Model(&models.Log{}).Where("created_at < ?", time.Now().Add(-streamGapWait)).
+		Select("COALESCE(MAX(id), 0)").Scan(&start).Error; err != nil {
+		return 0, nil, err
+	}
+	var ids []uint
+	if err := config.DB.Unscoped().Model(&models.Log{}).Where("id > ?", start).Pluck("id", &ids).Error; err != nil {
+		return 0, nil, err
+	}
+	skip := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		skip[id] = true
+	}
+	return start, skip, nil
+}
+
+// sendNewLogs sends the logs after the cursor that match query in ID order,
+// advancing the cursor, and skips those in skip. It stops before a missing ID
+// whose next log was created less than streamGapWait ago, as the missing log
+// may still be committed, and then reports gap. Holes left by rolled back or
+// purged logs are passed once that time is up.
+func sendNewLogs(query *gorm.DB, cursor *streamCursor, skip map[uint]bool, sendLog func(string, models.Log) bool) (sent int, gap bool, ok bool) {
+	for {
+		var rows []struct {
+			ID        uint
+			CreatedAt time.Time
+		}
+		if err := config.DB.Unscoped().Model(&models.Log{}).Select("id, created_at").
+			Where("id > ?", cursor.LogID).Order("id").Limit(streamReplayBatch).Scan(&rows).Error; err != nil {
+			return sent, false, false
+		}
+
+		from, upTo := cursor.LogID, cursor.LogID
+		for _, r := range rows {
+			if r.ID != upTo+1 && time.Since(r.CreatedAt) < streamGapWait {
+				gap = true
+				break
+			}
+			upTo = r.ID
+		}
+		if upTo == from {
+			return sent, gap, true
+		}
+
+		var logs []models.Log
+		if err := query.Where("id > ? AND id <= ?", from, upTo).Order("id").Find(&logs).Error; err != nil {
+			return sent, false, false
+		}
+		if err := loadLogTags(logs); err != nil {
+			return sent, false, false
+		}
+		for _, l := range logs {
+			if skip[l.ID] {
+				continue
+			}
+			cursor.LogID = l.ID
+			if !sendLog(services.LogEventCreated, l) {
+				return sent, false, false
+			}
+			sent++
+		}
+		cursor.LogID = upTo
+		for id := range skip {
+			if id <= upTo {
+				delete(skip, id)
+			}
+		}
+		if gap || len(rows) < streamReplayBatch {
+			return sent, gap, true
+		}
+	}
+}
+
+// replayLogReviews sends the reviews made after the cursor on the logs up to
+// seenUpTo, which the client had already seen, advancing the cursor. Reviews
+// reset to unreviewed have no time and are not replayed.
+func replayLogReviews(query *gorm.DB, cursor *streamCursor, seenUpTo uint, sendLog func(string, models.Log) bool) (int, bool) {
+	replayed := 0
+
+	for {
+		reviewedAt := time.UnixMilli(cursor.ReviewMs).UTC()
+		var logs []models.Log
+		if err := query.
+			Where("reviewed_at IS NOT NULL AND id <= ?", seenUpTo).
+			Where("reviewed_at > ? OR (reviewed_at = ? AND id > ?)", reviewedAt, reviewedAt, cursor.ReviewID).
+			Order("reviewed_at, id").Limit(streamReplayBatch).Find(&logs).Error; err != nil {
+			return replayed, false
+		}
+		if err := loadLogTags(logs); err != nil {
+			return replayed, false
+		}
+		for _, l := range logs {
+			cursor.ReviewMs, cursor.ReviewID = l.ReviewedAt.UnixMilli(), l.ID
+			if !sendLog(services.LogEventReviewed, l) {
+				return replayed, false
+			}
+			replayed++
+		}
+		if len(logs) < streamReplayBatch {
+			return replayed, true
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package controllers
+
+import (
+	"comproBackend/config"
+	"comproBackend/models"
+	"comproBackend/services"
+	"comproBackend/utils"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
+)
+
+const maxAttendanceDays = 92
+
+type personAttendance struct {
+	Name                   string `json:"name"`
+	Team                   string `json:"team"`
+	DaysPresent            int    `json:"days_present"`
+	DaysAbsent             int    `json:"days_absent"`
+	LateArrivals           int    `json:"late_arrivals"`
+	PresenceSeconds        int64  `json:"presence_seconds"`
+	AveragePresenceSeconds int64  `json:"average_presence_seconds"`
+	AverageFirstIn         string `json:"average_first_in,omitempty"` // HH:MM local
+	firstInMinutes         int
+}
+
+type teamAttendance struct {
+	Team            string `json:"team"`
+	Members         int    `json:"members"`
+	DaysPresent     int    `json:"days_present"`
+	Absences        int    `json:"absences"`
+	LateArrivals    int    `json:"late_arrivals"`
+	PresenceSeconds int64  `json:"presence_seconds"`
+}
+
+// GetAttendanceReport derives attendance from authorized detections: first-in,
+// last-out and presence per person and day, late arrivals against the office
+// schedule and absences of roster members. view selects daily rows (default),
+// a per-person or a per-team summary; format=csv downloads the view.
+func GetAttendanceReport(c *gin.Context) {
+	loc, err := requestLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	start, end, err := parsePeriod(c, loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	view := c.DefaultQuery("view", "daily")
+	if view != "daily" && view != "person" && view != "team" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid view. Use daily, person or team"})
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format. Use json or csv"})
+		return
+	}
+
+	var days []time.Time
+	for day := start; day.Before(end); {
+		days = append(days, day)
+		y, m, d := day.In(loc).Date()
+		day = utils.LocalMidnight(y, m, d+1, loc)
+	}
+	if len(days) > maxAttendanceDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Period is too long, at most %d days", maxAttendanceDays)})
+		return
+	}
+
+	membersQuery := config.DB.Model(&models.AttendanceMember{})
+	if team := c.Query("team"); team != "" {
+		membersQuery = membersQuery.Where("team = ?", team)
+	}
+	if name := c.Query("name"); name != "" {
+		membersQuery = membersQuery.Where("name = ?", name)
+	}
+	var members []models.AttendanceMember
+	if err := membersQuery.Find(&members).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	teams := make(map[string]string, len(members))
+	names := make([]string, len(members))
+	for i, m := range members {
+		teams[m.Name] = m.Team
+		names[i] = m.Name
+	}
+
+	eventsQuery := config.DB.Model(&models.Log{}).
+		Select("name, camera_id, occurred_at").
+		Where("authorized = ? AND name <> ?", true, "Unknown").
+		Where("occurred_at >= ? AND occurred_at < ?", start, end)
+	if name := c.Query("name"); name != "" {
+		eventsQuery = eventsQuery.Where("name = ?", name)
+	}
+	var events []services.AttendanceEvent
+	// a team only covers its roster members
+	if c.Query("team") == "" || len(names) > 0 {
+		if c.Query("team") != "" {
+			eventsQuery = eventsQuery.Where("name IN ?", names)
+		}
+		if err := eventsQuery.Order("occurred_at").Order("id").Scan(&events).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	cfg := services.Attendance()
+	rows := services.ComputeAttendance(cfg, events, teams, days, time.Now(), loc)
+
+	var data interface{} = rows
+	switch view {
+	case "person":
+		data = summarizeByPerson(rows)
+	case "team":
+		data = summarizeByTeam(rows)
+	}
+
+	if format == "csv" {
+		writeAttendanceCSV(c, view, data, start, end, loc)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"view":     view,
+		"timezone": loc.String(),
+		"start":    start.In(loc),
+		"end":      end.In(loc),
+		"schedule": attendanceSchedule(cfg),
+		"data":     data,
+	})
+}
+
+func attendanceSchedule(cfg services.AttendanceConfig) gin.H {
+	var workdays []int
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if cfg.Workdays[d] {
+			workdays = append(workdays, int(d))
+		}
+	}
+	return gin.H{
+		"start":         fmt.Sprintf("%02d:%02d", int(cfg.StartTime/time.Hour), int(cfg.StartTime%time.Hour/time.Minute)),
+		"grace_minutes": int(cfg.Grace / time.Minute),
+		"workdays":      workdays,
+		"entry_cameras": setKeys(cfg.EntryCameras),
+		"exit_cameras":  setKeys(cfg.ExitCameras),
+	}
+}
+
+func setKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func summarizeByPerson(rows []services.AttendanceDay) []personAttendance {
+	byName := make(map[string]*personAttendance)
+	var order []string
+	for _, r := range rows {
+		p, ok := byName[r.Name]
+		if !ok {
+			p = &personAttendance{Name: r.Name, Team: r.Team}
+			byName[r.Name] = p
+			order = append(order, r.Name)
+		}
+		if r.Absent {
+			p.DaysAbsent++
+			continue
+		}
+		p.DaysPresent++
+		p.PresenceSeconds += r.PresenceSeconds
+		p.firstInMinutes += r.FirstIn.Hour()*60 + r.FirstIn.Minute()
+		if r.Late {
+			p.LateArrivals++
+		}
+	}
+
+	sort.Strings(order)
+	out := make([]personAttendance, len(order))
+	for i, name := range order {
+		p := byName[name]
+		if p.DaysPresent > 0 {
+			p.AveragePresenceSeconds = p.PresenceSeconds / int64(p.DaysPresent)
+			avg := p.firstInMinutes / p.DaysPresent
+			p.AverageFirstIn = fmt.Sprintf("%02d:%02d", avg/60, avg%60)
+		}
+		out[i] = *p
+	}
+	return out
+}
+
+func summarizeByTeam(rows []services.AttendanceDay) []teamAttendance {
+	byTeam := make(map[string]*teamAttendance)
+	members := make(map[string]map[string]bool)
+	for _, r := range rows {
+		t, ok := byTeam[r.Team]
+		if !ok {
+			t = &teamAttendance{Team: r.Team}
+			byTeam[r.Team] = t
+			members[r.Team] = make(map[string]bool)
+		}
+		members[r.Team][r.Name] = true
+		if r.Absent {
+			t.Absences++
+			continue
+		}
+		t.DaysPresent++
+		t.PresenceSeconds += r.PresenceSeconds
+		if r.Late {
+			t.LateArrivals++
+		}
+	}
+
+	out := make([]teamAttendance, 0, len(byTeam))
+	for team, t := range byTeam {
+		t.Members = len(members[team])
+		out = append(out, *t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Team < out[j].Team })
+	return out
+}
+
+func writeAttendanceCSV(c *gin.Context, view string, data interface{}, start, end time.Time, loc *time.Location) {
+	var columns []services.ExportColumn
+	var records [][]interface{}
+	clock := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Format("15:04:05")
+	}
+
+	switch rows := data.(type) {
+	case []services.AttendanceDay:
+		columns = exportTitles("Date", "Name", "Team", "First In", "Last Out", "Presence", "Detections", "Late", "Late By", "Absent")
+		for _, r := range rows {
+			records = append(records, []interface{}{r.Date, r.Name, r.Team, clock(r.FirstIn), clock(r.LastOut),
+				services.FormatSeconds(r.PresenceSeconds), r.Detections, r.Late, services.FormatSeconds(r.LateBySeconds), r.Absent})
+		}
+	case []personAttendance:
+		columns = exportTitles("Name", "Team", "Days Present", "Days Absent", "Late Arrivals", "Presence", "Average Presence", "Average First In")
+		for _, r := range rows {
+			records = append(records, []interface{}{r.Name, r.Team, r.DaysPresent, r.DaysAbsent, r.LateArrivals,
+				services.FormatSeconds(r.PresenceSeconds), services.FormatSeconds(r.AveragePresenceSeconds), r.AverageFirstIn})
+		}
+	case []teamAttendance:
+		columns = exportTitles("Team", "Members", "Days Present", "Absences", "Late Arrivals", "Presence")
+		for _, r := range rows {
+			records = append(records, []interface{}{r.Team, r.Members, r.DaysPresent, r.Absences, r.LateArrivals,
+				services.FormatSeconds(r.PresenceSeconds)})
+		}
+	}
+
+	filename := fmt.Sprintf("attendance_%s_%s_%s.csv", view, start.In(loc).Format("20060102"), end.Add(-time.Second).In(loc).Format("20060102"))
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Status(http.StatusOK)
+
+	writer, err := services.NewCSVTableWriter(c.Writer, columns)
+	if err != nil {
+		return
+	}
+	for _, record := range records {
+		if err := writer.WriteRow(record); err != nil {
+			return
+		}
+	}
+	writer.Close()
+}
+
+func exportTitles(titles ...string) []services.ExportColumn {
+	columns := make([]services.ExportColumn, len(titles))
+	for i, title := range titles {
+		columns[i] = services.ExportColumn{Title: title}
+	}
+	return columns
+}
+
+// GetAttendanceMembers lists the attendance roster.
+func GetAttendanceMembers(c *gin.Context) {
+	var members []models.AttendanceMember
+	if err := config.DB.Order("team").Order("name").Find(&members).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": members})
+}
+
+// SaveAttendanceMember adds a person to the roster or changes their team.
+func SaveAttendanceMember(c *gin.Context) {
+	if c.GetString("role") != "verificator" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	var input struct {
+		Name string `json:"name" binding:"required"`
+		Team string `json:"team"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	input.Name, input.Team = strings.TrimSpace(input.Name), strings.TrimSpace(input.Team)
+	if input.Name == "" || len(input.Name) > 255 || len(input.Team) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid name or team"})
+		return
+	}
+
+	member := models.AttendanceMember{Name: input.Name, Team: input.Team}
+	if err := config.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"team", "updated_at"}),
+	}).Create(&member).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := config.DB.Where("name = ?", input.Name).First(&member).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member saved", "data": member})
+}
+
+// DeleteAttendanceMember removes a person from the roster. Their detections
+// stay in the reports but they are no longer marked absent.
+func DeleteAttendanceMember(c *gin.Context) {
+	if c.GetString("role") != "verificator" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	result := config.DB.Delete(&models.AttendanceMember{}, uint(id))
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed"})
+}
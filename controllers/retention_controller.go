@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"comproBackend/services"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetRetentionPreview reports what the next purge would delete without
+// changing anything.
+func GetRetentionPreview(c *gin.Context) {
+	if c.GetString("role") != "verificator" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	result, err := services.PurgeExpiredLogs(services.Retention, time.Now(), true)
+	if err != nil {
+		if errors.Is(err, services.ErrRetentionRunning) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A purge is currently running, try again later"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"policy": services.Retention, "enabled": services.Retention.Enabled(), "data": result})
+}
+
+// RunRetentionPurge runs a purge immediately instead of waiting for the
+// background worker.
+func RunRetentionPurge(c *gin.Context) {
+	if c.GetString("role") != "verificator" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	if !services.Retention.Enabled() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No retention period configured"})
+		return
+	}
+
+	result, err := services.PurgeExpiredLogs(services.Retention, time.Now(), false)
+	if err != nil {
+		if errors.Is(err, services.ErrRetentionRunning) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A purge is already running"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "data": result})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Purge completed", "data": result})
+}
@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"comproBackend/services"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Search fuzzily matches people across log names, app users and enrolled
+// faces. Query: q (required), type (comma separated log|user|face), limit
+// (default 20, max 100).
+func Search(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+	if len(q) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is too long"})
+		return
+	}
+
+	var types []string
+	if v := c.Query("type"); v != "" {
+		for _, t := range strings.Split(v, ",") {
+			t = strings.TrimSpace(t)
+			if !slices.Contains(services.SearchTypes, t) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid type. Use " + strings.Join(services.SearchTypes, ", ")})
+				return
+			}
+			types = append(types, t)
+		}
+	}
+
+	limit := 20
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+			return
+		}
+		limit = n
+	}
+
+	results, facesLive, err := services.Search(q, types, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": results, "count": len(results), "query": q, "faces_live": facesLive})
+}
@@ -2,8 +2,10 @@ package controllers
 
 import (
 	"comproBackend/config"
+	"comproBackend/events"
 	"comproBackend/middleware"
 	"comproBackend/models"
+	"comproBackend/utils"
 	"net/http"
 	"time"
 
@@ -59,6 +61,8 @@ func Register(c *gin.Context) {
 		return
 	}
 
+	utils.Publish(events.New(events.TypeUserPending, events.SourceBackend, events.UserPendingPayload{ID: User.ID, Username: User.Username, Reason: "registration"}))
+
 	c.JSON(http.StatusCreated, gin.H{"message": "User created successfully, wait for verificator approval", "data": User})
 }
 
@@ -178,6 +182,8 @@ func ResetPassword(c *gin.Context) {
 		return
 	}
 
+	utils.Publish(events.New(events.TypeUserPending, events.SourceBackend, events.UserPendingPayload{ID: user.ID, Username: user.Username, Reason: "password_reset"}))
+
 	c.JSON(http.StatusOK, gin.H{"message": "wait for verificator approval"})
 
 }
@@ -226,6 +232,7 @@ func Approval(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		broadcastApproval(user, "reject", c.GetString("username"))
 		c.JSON(http.StatusOK, gin.H{"message": message, "data": user})
 		return
 	}
@@ -241,9 +248,21 @@ func Approval(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	broadcastApproval(user, "approve", c.GetString("username"))
 	c.JSON(http.StatusOK, gin.H{"message": "User Approved successfully", "data": user})
 }
 
+// broadcastApproval tells other verificators that a request was handled.
+func broadcastApproval(user models.User, action, by string) {
+	utils.Publish(events.New(events.TypeUserApproval, events.SourceBackend, events.UserApprovalPayload{
+		ID:       user.ID,
+		Username: user.Username,
+		Role:     user.Role,
+		Action:   action,
+		By:       by,
+	}))
+}
+
 func UpdateFCMToken(c *gin.Context) {
 	var input struct {
 		FCMToken string `json:"fcm_token"`
@@ -0,0 +1,32 @@
+package controllers
+
+import (
+	"comproBackend/middleware"
+	"comproBackend/models"
+	"comproBackend/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateWsTicket issues a one-time ticket for browsers, which cannot send an
+// Authorization header when opening /api/ws?ticket=...
+func CreateWsTicket(c *gin.Context) {
+	identity := middleware.Identity{
+		UserID:   c.GetUint("user_id"),
+		Username: c.GetString("username"),
+		Role:     c.GetString("role"),
+	}
+	if user, ok := c.Get("user"); ok {
+		u := user.(models.User)
+		identity.User = &u
+	}
+
+	ticket, expiresAt, err := utils.IssueWsTicket(identity)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ticket": ticket, "expires_at": expiresAt, "expires_in": int(utils.WsTicketTTL.Seconds())})
+}
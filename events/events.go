@@ -0,0 +1,278 @@
+// Package events defines the messages pushed to WebSocket clients.
+package events
+
+import (
+	"comproBackend/models"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// WebSocket protocol versions. Version 1 sends the legacy {"type", "data"}
+// messages; version 2 sends every event wrapped in an Envelope.
+const (
+	ProtocolV1     = 1
+	ProtocolV2     = 2
+	LatestProtocol = ProtocolV2
+)
+
+var SupportedProtocols = []int{ProtocolV1, ProtocolV2}
+
+// Event sources.
+const (
+	SourceBackend = "backend"
+	SourceCamera  = "camera" // the Python face recognition service
+	SourceAnomaly = "anomaly"
+)
+
+// Event types.
+const (
+	TypeDetection     = "detection"
+	TypeLog           = "log"
+	TypeLogReview     = "log_review"
+	TypeLogNote       = "log_note"
+	TypeLogTags       = "log_tags"
+	TypeCameraStatus  = "camera_status"
+	TypeCameraEvent   = "camera_event" // any other camera service event, passed through
+	TypeUserPending   = "user_pending"
+	TypeUserApproval  = "user_approval"
+	TypeAnomaly       = "anomaly"
+	TypeAnomalyReview = "anomaly_review"
+	TypeDoorUnlock    = "door_unlock"
+
+	// replies to a single client
+	TypeWelcome       = "welcome"
+	TypeSubscriptions = "subscriptions"
+	TypeAck           = "ack"
+	TypeError         = "error"
+	TypeResync        = "resync"
+	TypeCommandResult = "command_result"
+)
+
+// PayloadVersions is the payload schema version of each event type. Bump it
+// when a payload changes incompatibly and keep the old shape for clients that
+// negotiated an older protocol.
+var PayloadVersions = map[string]int{
+	TypeDetection:     1,
+	TypeLog:           1,
+	TypeLogReview:     1,
+	TypeLogNote:       1,
+	TypeLogTags:       1,
+	TypeCameraStatus:  1,
+	TypeCameraEvent:   1,
+	TypeUserPending:   1,
+	TypeUserApproval:  1,
+	TypeAnomaly:       1,
+	TypeAnomalyReview: 1,
+	TypeDoorUnlock:    1,
+
+	TypeWelcome:       1,
+	TypeSubscriptions: 1,
+	TypeAck:           1,
+	TypeError:         1,
+	TypeResync:        1,
+	TypeCommandResult: 1,
+}
+
+// Envelope wraps every event sent with protocol version 2. The schema is in
+// events.schema.json.
+type Envelope struct {
+	ID         string      `json:"id"`
+	Seq        uint64      `json:"seq,omitempty"` // journal position, see the last_seq parameter of /api/ws
+	Type       string      `json:"type"`
+	Version    int         `json:"version"`
+	Source     string      `json:"source"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	Payload    interface{} `json:"payload"`
+
+	legacy []byte // original message for protocol 1, when passed through
+}
+
+// New wraps payload in an envelope stamped now.
+func New(eventType, source string, payload interface{}) Envelope {
+	return Envelope{
+		ID:         newEventID(),
+		Type:       eventType,
+		Version:    PayloadVersions[eventType],
+		Source:     source,
+		OccurredAt: time.Now().UTC(),
+		Payload:    payload,
+	}
+}
+
+func newEventID() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// Legacy encodes the event the way protocol 1 clients expect it.
+func (e Envelope) Legacy() ([]byte, error) {
+	if e.legacy != nil {
+		return e.legacy, nil
+	}
+	return json.Marshal(map[string]interface{}{"type": e.Type, "data": e.Payload})
+}
+
+// DetectionPayload is a live recognition from the camera service.
+type DetectionPayload struct {
+	EventID    string  `json:"event_id,omitempty"`
+	Name       string  `json:"name"`
+	Authorized bool    `json:"authorized"`
+	Confidence float64 `json:"confidence"`
+	Role       string  `json:"role,omitempty"`
+	CameraID   string  `json:"camera_id,omitempty"`
+	Door       string  `json:"door,omitempty"`
+	Timestamp  string  `json:"timestamp,omitempty"`
+}
+
+// LogPayload is a newly stored log.
+type LogPayload = models.Log
+
+// LogReviewPayload is a changed review status.
+type LogReviewPayload struct {
+	LogID        uint            `json:"log_id"`
+	ReviewStatus string          `json:"review_status"`
+	ReviewedBy   string          `json:"reviewed_by"`
+	ReviewedAt   *time.Time      `json:"reviewed_at"`
+	Note         *models.LogNote `json:"note,omitempty"`
+}
+
+// LogNotePayload is a note added to a log.
+type LogNotePayload = models.LogNote
+
+// LogTagsPayload is the new tag list of a log.
+type LogTagsPayload struct {
+	LogID     uint     `json:"log_id"`
+	Tags      []string `json:"tags"`
+	UpdatedBy string   `json:"updated_by"`
+}
+
+// CameraStatusPayload is the camera service answer to a start or stop.
+type CameraStatusPayload struct {
+	Action string                 `json:"action"` // start | stop
+	Result map[string]interface{} `json:"result"`
+}
+
+// CameraEventPayload carries a camera service event this backend does not
+// know, unchanged.
+type CameraEventPayload struct {
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+// UserPendingPayload is a registration or password reset waiting for a
+// verificator.
+type UserPendingPayload struct {
+	ID       uint   `json:"id"`
+	Username string `json:"username"`
+	Reason   string `json:"reason"` // registration | password_reset
+}
+
+// UserApprovalPayload is a handled registration or reset request.
+type UserApprovalPayload struct {
+	ID       uint   `json:"id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	Action   string `json:"action"` // approve | reject
+	By       string `json:"by"`
+}
+
+// WelcomePayload confirms the negotiated protocol. Seq is the latest journal
+// position, to pass as last_seq when reconnecting.
+type WelcomePayload struct {
+	Protocol        int            `json:"protocol"`
+	Seq             uint64         `json:"seq"`
+	Supported       []int          `json:"supported"`
+	PayloadVersions map[string]int `json:"payload_versions"`
+	Username        string         `json:"username"`
+	Role            string         `json:"role"`
+}
+
+// ResyncPayload tells a resuming client that events after LastSeq are no
+// longer in the journal; it has to reload its state over the REST API.
+type ResyncPayload struct {
+	LastSeq  uint64 `json:"last_seq"`
+	FirstSeq uint64 `json:"first_seq"` // oldest event still available
+	Seq      uint64 `json:"seq"`       // latest event
+	Reason   string `json:"reason"`
+}
+
+// AckPayload answers a log sent over the socket.
+type AckPayload struct {
+	Status    string `json:"status"` // saved
+	ID        uint   `json:"id"`
+	Duplicate bool   `json:"duplicate"`
+}
+
+// ErrorPayload reports a rejected client message.
+type ErrorPayload struct {
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// AnomalyPayload is a raised anomaly.
+type AnomalyPayload = models.Anomaly
+
+// AnomalyReviewPayload is a changed anomaly status.
+type AnomalyReviewPayload struct {
+	ID         uint       `json:"id"`
+	Kind       string     `json:"kind"`
+	Name       string     `json:"name"`
+	Status     string     `json:"status"`
+	ReviewedBy string     `json:"reviewed_by"`
+	ReviewedAt *time.Time `json:"reviewed_at"`
+}
+
+// NewAnomalyReviewPayload describes the current review state of a.
+func NewAnomalyReviewPayload(a models.Anomaly) AnomalyReviewPayload {
+	return AnomalyReviewPayload{ID: a.ID, Kind: a.Kind, Name: a.Name, Status: a.Status, ReviewedBy: a.ReviewedBy, ReviewedAt: a.ReviewedAt}
+}
+
+// DoorUnlockPayload is a door opened remotely.
+type DoorUnlockPayload struct {
+	Door        string                 `json:"door"`
+	RequestedBy string                 `json:"requested_by"`
+	Result      map[string]interface{} `json:"result"`
+}
+
+// CommandResultPayload answers a command sent over the socket. ID is the
+// client's correlation ID; Status follows HTTP status codes.
+type CommandResultPayload struct {
+	ID      string      `json:"id"`
+	Command string      `json:"command"`
+	OK      bool        `json:"ok"`
+	Status  int         `json:"status"`
+	Result  interface{} `json:"result,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// FromCameraEvent converts a message from the camera service event stream.
+// Protocol 1 clients keep receiving the original bytes.
+func FromCameraEvent(raw []byte) (Envelope, error) {
+	var msg struct {
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return Envelope{}, err
+	}
+	var e Envelope
+	if msg.Type == TypeDetection {
+		var d DetectionPayload
+		if err := json.Unmarshal(msg.Data, &d); err != nil {
+			return Envelope{}, err
+		}
+		e = New(TypeDetection, SourceCamera, d)
+		if d.Timestamp != "" {
+			if t, err := models.ParseTimestamp(d.Timestamp, models.NaiveTimestampLocation); err == nil {
+				e.OccurredAt = t
+			}
+		}
+	} else {
+		e = New(TypeCameraEvent, SourceCamera, CameraEventPayload{Event: msg.Type, Data: msg.Data})
+	}
+	e.legacy = append([]byte(nil), raw...)
+	return e, nil
+}
@@ -0,0 +1,303 @@
+// Package facesvc is the client for the Python face recognition service,
+// which runs the camera, keeps the enrolled faces and emits detection events.
+package facesvc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultURL     = "http://localhost:5000"
+	DefaultTimeout = 10 * time.Second
+
+	// MaxImageSize bounds snapshots read from the service.
+	MaxImageSize = 8 << 20
+	maxBodySize  = 16 << 20
+)
+
+// Config selects the service a Client talks to.
+type Config struct {
+	BaseURL string        // e.g. http://localhost:5000
+	Token   string        // sent as "Authorization: Bearer <token>" when set
+	Timeout time.Duration // per request, streams excluded
+}
+
+// Client calls the face recognition service. It is safe for concurrent use.
+type Client struct {
+	baseURL string
+	token   string
+	timeout time.Duration
+	http    *http.Client
+}
+
+// New returns a client for cfg. An empty BaseURL or Timeout uses the
+// defaults.
+func New(cfg Config) *Client {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = DefaultURL
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	return &Client{
+		baseURL: strings.TrimRight(cfg.BaseURL, "/"),
+		token:   cfg.Token,
+		timeout: cfg.Timeout,
+		http:    &http.Client{},
+	}
+}
+
+// BaseURL returns the service URL without a trailing slash.
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+var (
+	defaultMu     sync.Mutex
+	defaultClient *Client
+)
+
+// Default returns the client configured by FACE_SERVICE_URL,
+// FACE_SERVICE_TOKEN and FACE_SERVICE_TIMEOUT, read on first use.
+func Default() *Client {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultClient == nil {
+		defaultClient = New(LoadConfig())
+	}
+	return defaultClient
+}
+
+// SetDefault replaces the client returned by Default, e.g. with one pointed
+// at a facesvctest.Server.
+func SetDefault(c *Client) {
+	defaultMu.Lock()
+	defaultClient = c
+	defaultMu.Unlock()
+}
+
+// LoadConfig reads the FACE_SERVICE_* environment variables.
+func LoadConfig() Config {
+	cfg := Config{
+		BaseURL: os.Getenv("FACE_SERVICE_URL"),
+		Token:   os.Getenv("FACE_SERVICE_TOKEN"),
+		Timeout: DefaultTimeout,
+	}
+	if v := os.Getenv("FACE_SERVICE_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			cfg.Timeout = d
+		} else {
+			log.Printf("Warning: invalid FACE_SERVICE_TIMEOUT %q, using %s", v, DefaultTimeout)
+		}
+	}
+	return cfg
+}
+
+// Status returns the camera status.
+func (c *Client) Status(ctx context.Context) (Result, error) {
+	var result Result
+	err := c.do(ctx, http.MethodGet, "/api/camera/status", nil, &result)
+	return result, err
+}
+
+// StartCamera starts capturing.
+func (c *Client) StartCamera(ctx context.Context) (Result, error) {
+	var result Result
+	err := c.do(ctx, http.MethodPost, "/api/camera/start", nil, &result)
+	return result, err
+}
+
+// StopCamera stops capturing.
+func (c *Client) StopCamera(ctx context.Context) (Result, error) {
+	var result Result
+	err := c.do(ctx, http.MethodPost, "/api/camera/stop", nil, &result)
+	return result, err
+}
+
+// Config returns the camera configuration.
+func (c *Client) Config(ctx context.Context) (Document, error) {
+	var doc Document
+	err := c.do(ctx, http.MethodGet, "/api/camera/config", nil, &doc)
+	return doc, err
+}
+
+// UpdateConfig replaces the camera configuration.
+func (c *Client) UpdateConfig(ctx context.Context, doc Document) (Result, error) {
+	var result Result
+	err := c.do(ctx, http.MethodPost, "/api/camera/config", doc, &result)
+	return result, err
+}
+
+// Zones returns the detection zones.
+func (c *Client) Zones(ctx context.Context) (Document, error) {
+	var doc Document
+	err := c.do(ctx, http.MethodGet, "/api/camera/zones", nil, &doc)
+	return doc, err
+}
+
+// UpdateZones replaces the detection zones.
+func (c *Client) UpdateZones(ctx context.Context, doc Document) (Result, error) {
+	var result Result
+	err := c.do(ctx, http.MethodPost, "/api/camera/zones", doc, &result)
+	return result, err
+}
+
+// SetupDroidCam switches the camera source to a DroidCam phone.
+func (c *Client) SetupDroidCam(ctx context.Context, req DroidCamRequest) (Result, error) {
+	var result Result
+	err := c.do(ctx, http.MethodPost, "/api/camera/test/droidcam", req, &result)
+	return result, err
+}
+
+// SetupRTSP switches the camera source to an RTSP stream.
+func (c *Client) SetupRTSP(ctx context.Context, req RTSPRequest) (Result, error) {
+	var result Result
+	err := c.do(ctx, http.MethodPost, "/api/camera/test/rtsp", req, &result)
+	return result, err
+}
+
+// Users lists the enrolled faces.
+func (c *Client) Users(ctx context.Context) ([]FaceUser, error) {
+	var body UsersResponse
+	if err := c.do(ctx, http.MethodGet, "/api/users", nil, &body); err != nil {
+		return nil, err
+	}
+	return body.Users, nil
+}
+
+// Enroll registers a face from uploaded images.
+func (c *Client) Enroll(ctx context.Context, req EnrollRequest) (Result, error) {
+	var result Result
+	err := c.do(ctx, http.MethodPost, "/api/enroll", req, &result)
+	return result, err
+}
+
+// EnrollCapture registers a face from the current camera frame.
+func (c *Client) EnrollCapture(ctx context.Context, req CaptureEnrollRequest) (Result, error) {
+	var result Result
+	err := c.do(ctx, http.MethodPost, "/api/enroll/capture", req, &result)
+	return result, err
+}
+
+// DeleteUser removes an enrolled face.
+func (c *Client) DeleteUser(ctx context.Context, name string) (Result, error) {
+	var result Result
+	err := c.do(ctx, http.MethodDelete, "/api/users/"+url.PathEscape(name), nil, &result)
+	return result, err
+}
+
+// AddSample adds an image to an enrolled face.
+func (c *Client) AddSample(ctx context.Context, name string, req AddSampleRequest) (Result, error) {
+	var result Result
+	err := c.do(ctx, http.MethodPost, "/api/users/"+url.PathEscape(name)+"/add-sample", req, &result)
+	return result, err
+}
+
+// Snapshot returns the current camera frame as JPEG.
+func (c *Client) Snapshot(ctx context.Context) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	resp, err := c.send(ctx, http.MethodGet, "/api/camera/snapshot", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxImageSize))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	return data, nil
+}
+
+// Stream opens the MJPEG stream of cameraID, "" for the default camera. The
+// request timeout does not apply; the stream stays open until ctx ends or it
+// is closed.
+func (c *Client) Stream(ctx context.Context, cameraID string) (*Stream, error) {
+	path := "/api/camera/stream"
+	if cameraID != "" {
+		path += "?camera_id=" + url.QueryEscape(cameraID)
+	}
+	resp, err := c.send(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Stream{ReadCloser: resp.Body, ContentType: resp.Header.Get("Content-Type")}, nil
+}
+
+// Events opens the Server-Sent Events stream of detections and camera
+// status. Like Stream it has no timeout.
+func (c *Client) Events(ctx context.Context) (*Stream, error) {
+	resp, err := c.send(ctx, http.MethodGet, "/api/camera/events", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Stream{ReadCloser: resp.Body, ContentType: resp.Header.Get("Content-Type")}, nil
+}
+
+// do sends in as JSON and decodes the answer into out, within the client
+// timeout.
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	resp, err := c.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxBodySize)).Decode(out)
+	if err != nil && !errors.Is(err, io.EOF) {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w: %w", ErrUnavailable, err)
+		}
+		return fmt.Errorf("%w: %s %s: %w", ErrBadResponse, method, path, err)
+	}
+	return nil
+}
+
+// send performs a request and turns transport failures and non-2xx answers
+// into errors. On success the caller closes the body.
+func (c *Client) send(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		return nil, newError(resp)
+	}
+	return resp, nil
+}
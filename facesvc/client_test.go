@@ -0,0 +1,208 @@
+package facesvc_test
+
+import (
+	"bufio"
+	"comproBackend/facesvc"
+	"comproBackend/facesvc/facesvctest"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newFake(t *testing.T) (*facesvctest.Server, *facesvc.Client) {
+	t.Helper()
+	fake := facesvctest.NewServer()
+	t.Cleanup(fake.Close)
+	return fake, facesvc.New(facesvc.Config{BaseURL: fake.URL})
+}
+
+func TestClientCamera(t *testing.T) {
+	fake, client := newFake(t)
+	ctx := context.Background()
+
+	if _, err := client.StartCamera(ctx); err != nil {
+		t.Fatal(err)
+	}
+	status, err := client.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status["running"] != true || !fake.Running() {
+		t.Fatalf("camera not running after start, status %v", status)
+	}
+
+	if _, err := client.UpdateConfig(ctx, facesvc.Document(`{"fps":5}`)); err != nil {
+		t.Fatal(err)
+	}
+	config, err := client.Config(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(config) != `{"fps":5}` {
+		t.Fatalf("config = %s", config)
+	}
+
+	frame, err := client.Snapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frame) < 2 || frame[0] != 0xFF || frame[1] != 0xD8 {
+		t.Fatal("snapshot is not a JPEG")
+	}
+}
+
+func TestClientFaces(t *testing.T) {
+	fake, client := newFake(t)
+	ctx := context.Background()
+
+	if _, err := client.Enroll(ctx, facesvc.EnrollRequest{Name: "Jane Doe", Images: []string{"a", "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.AddSample(ctx, "Jane Doe", facesvc.AddSampleRequest{Image: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	users, err := client.Users(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0] != (facesvc.FaceUser{Name: "Jane Doe", SampleCount: 3}) {
+		t.Fatalf("users = %+v", users)
+	}
+
+	if _, err := client.DeleteUser(ctx, "Jane Doe"); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.Users()) != 0 {
+		t.Fatalf("user not deleted: %v", fake.Users())
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	fake, client := newFake(t)
+	ctx := context.Background()
+	enroll := facesvc.EnrollRequest{Name: "Jane", Images: []string{"a"}}
+	if _, err := client.Enroll(ctx, enroll); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := client.Enroll(ctx, enroll)
+	var svcErr *facesvc.Error
+	if !errors.As(err, &svcErr) || !errors.Is(err, facesvc.ErrConflict) {
+		t.Fatalf("duplicate enroll: %v", err)
+	}
+	if svcErr.StatusCode != http.StatusConflict || svcErr.Message != "user Jane already exists" || svcErr.Body.ErrorMessage() != svcErr.Message {
+		t.Fatalf("duplicate enroll: %+v", svcErr)
+	}
+
+	if _, err := client.DeleteUser(ctx, "nobody"); !errors.Is(err, facesvc.ErrNotFound) {
+		t.Fatalf("delete unknown user: %v", err)
+	}
+
+	fake.Fail("POST /api/camera/test/rtsp", http.StatusUnprocessableEntity, map[string]string{"message": "cannot open stream"})
+	_, err = client.SetupRTSP(ctx, facesvc.RTSPRequest{URL: "rtsp://camera"})
+	if !errors.Is(err, facesvc.ErrInvalid) || !errors.As(err, &svcErr) || svcErr.Message != "cannot open stream" {
+		t.Fatalf("rejected rtsp setup: %v", err)
+	}
+
+	fake.Fail("GET /api/camera/status", http.StatusInternalServerError, nil)
+	_, err = client.Status(ctx)
+	if !errors.As(err, &svcErr) || svcErr.StatusCode != http.StatusInternalServerError || svcErr.Message != "Internal Server Error" {
+		t.Fatalf("status without body: %v", err)
+	}
+}
+
+func TestClientToken(t *testing.T) {
+	fake, anonymous := newFake(t)
+	fake.Token = "secret"
+
+	if _, err := anonymous.Users(context.Background()); !errors.Is(err, facesvc.ErrUnauthorized) {
+		t.Fatalf("without token: %v", err)
+	}
+	authorized := facesvc.New(facesvc.Config{BaseURL: fake.URL, Token: "secret"})
+	if _, err := authorized.Users(context.Background()); err != nil {
+		t.Fatalf("with token: %v", err)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	client := facesvc.New(facesvc.Config{BaseURL: slow.URL, Timeout: 100 * time.Millisecond})
+	started := time.Now()
+	_, err := client.Status(context.Background())
+	if !errors.Is(err, facesvc.ErrUnavailable) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("slow service: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Fatalf("timeout took %s", elapsed)
+	}
+}
+
+func TestClientEvents(t *testing.T) {
+	fake, client := newFake(t)
+	stream, err := client.Events(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	if err := fake.SendEvent("detection", map[string]string{"name": "Jane"}); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bufio.NewReader(stream)
+	for _, want := range []string{"event: detection\n", `data: {"name":"Jane"}` + "\n"} {
+		line, err := reader.ReadString('\n')
+		if err != nil || line != want {
+			t.Fatalf("read %q, %v; want %q", line, err, want)
+		}
+	}
+}
+
+func TestFailure(t *testing.T) {
+	logOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logOutput)
+
+	fake, client := newFake(t)
+	ctx := context.Background()
+	_, notFound := client.DeleteUser(ctx, "nobody")
+	fake.Token = "secret"
+	_, unauthorized := client.Users(ctx)
+	_, unreachable := facesvc.New(facesvc.Config{BaseURL: "http://127.0.0.1:1"}).Users(ctx)
+	timeout := errors.Join(facesvc.ErrUnavailable, context.DeadlineExceeded)
+
+	tests := []struct {
+		name    string
+		err     error
+		status  int
+		message string
+	}{
+		{"service answer", notFound, http.StatusNotFound, "user nobody not found"},
+		{"wrong token", unauthorized, http.StatusBadGateway, "fallback"},
+		{"unreachable", unreachable, http.StatusBadGateway, "fallback"},
+		{"timeout", timeout, http.StatusGatewayTimeout, "fallback"},
+	}
+	for _, tt := range tests {
+		status, body, message := facesvc.Failure(tt.err, "fallback")
+		if status != tt.status || message != tt.message || body.ErrorMessage() != tt.message {
+			t.Errorf("%s: got %d %v %q, want %d %q", tt.name, status, body, message, tt.status, tt.message)
+		}
+	}
+	if strings.Contains(unreachable.Error(), "fallback") {
+		t.Error("fallback leaked into the error")
+	}
+}
@@ -0,0 +1,95 @@
+package facesvc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Errors to test with errors.Is. A transport failure or timeout matches
+// ErrUnavailable; an *Error matches the sentinel of its status code.
+var (
+	ErrUnavailable  = errors.New("face service unavailable")
+	ErrBadResponse  = errors.New("invalid response from face service")
+	ErrInvalid      = errors.New("face service rejected the request")
+	ErrUnauthorized = errors.New("face service refused the credentials")
+	ErrNotFound     = errors.New("not found in face service")
+	ErrConflict     = errors.New("conflict in face service")
+)
+
+// Error is a non-2xx answer of the service.
+type Error struct {
+	StatusCode int
+	Message    string // the answer's "error" or "message" field, or the status text
+	Body       Result // the decoded answer, nil when it was not a JSON object
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("face service returned %d: %s", e.StatusCode, e.Message)
+}
+
+// Is maps the status code to the sentinel errors.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrInvalid:
+		return e.StatusCode == http.StatusBadRequest || e.StatusCode == http.StatusUnprocessableEntity
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrUnavailable:
+		return e.StatusCode == http.StatusBadGateway || e.StatusCode == http.StatusServiceUnavailable ||
+			e.StatusCode == http.StatusGatewayTimeout
+	}
+	return false
+}
+
+func newError(resp *http.Response) *Error {
+	e := &Error{StatusCode: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(data, &e.Body) == nil && e.Body != nil {
+		e.Message = e.Body.ErrorMessage()
+		if e.Message == "" {
+			e.Message = e.Body.Message()
+		}
+	} else if text := strings.TrimSpace(string(data)); text != "" && len(text) <= 200 && !json.Valid(data) {
+		e.Message = text
+	}
+	if e.Message == "" {
+		e.Message = http.StatusText(resp.StatusCode)
+	}
+	return e
+}
+
+// Failure maps an error of a Client call to the answer the backend gives its
+// own clients. When the service answered, its status code and body are
+// relayed. Otherwise the status is 502, or 504 if the call timed out, with
+// fallback as the message. A 401 or 403 from the service means our
+// FACE_SERVICE_TOKEN is wrong, not the caller's, so it also becomes 502.
+// message is a one-line description for replies that are not JSON bodies.
+func Failure(err error, fallback string) (status int, body Result, message string) {
+	var svcErr *Error
+	switch {
+	case errors.Is(err, ErrUnauthorized):
+		log.Println("Face service rejected FACE_SERVICE_TOKEN:", err)
+	case errors.As(err, &svcErr):
+		body = svcErr.Body
+		if body == nil {
+			body = Result{"error": svcErr.Message}
+		}
+		return svcErr.StatusCode, body, svcErr.Message
+	case errors.Is(err, context.DeadlineExceeded):
+		log.Println("Face service timeout:", err)
+		return http.StatusGatewayTimeout, Result{"error": fallback}, fallback
+	default:
+		log.Println("Face service error:", err)
+	}
+	return http.StatusBadGateway, Result{"error": fallback}, fallback
+}
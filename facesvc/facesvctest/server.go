@@ -0,0 +1,395 @@
+// Package facesvctest provides an in-memory face recognition service for
+// tests and local development without the Python service.
+package facesvctest
+
+import (
+	"bytes"
+	"comproBackend/facesvc"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const frameInterval = 100 * time.Millisecond
+
+// Server is a fake face recognition service. It keeps enrolled faces, camera
+// state, config and zones in memory, streams a fixed frame as MJPEG and
+// sends the events passed to SendEvent.
+type Server struct {
+	*httptest.Server
+	Token string // required as bearer token when set
+
+	mu       sync.Mutex
+	running  bool
+	config   json.RawMessage
+	zones    json.RawMessage
+	users    map[string]int
+	frame    []byte
+	failures map[string]failure
+	events   map[chan []byte]struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+type failure struct {
+	status int
+	body   interface{}
+}
+
+// NewServer starts a fake service. Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		config:   json.RawMessage(`{}`),
+		zones:    json.RawMessage(`{"zones":[]}`),
+		users:    make(map[string]int),
+		frame:    grayFrame(),
+		failures: make(map[string]failure),
+		events:   make(map[chan []byte]struct{}),
+		done:     make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/camera/status", s.status)
+	mux.HandleFunc("POST /api/camera/start", s.setRunning(true))
+	mux.HandleFunc("POST /api/camera/stop", s.setRunning(false))
+	mux.HandleFunc("GET /api/camera/config", s.getDocument(&s.config))
+	mux.HandleFunc("POST /api/camera/config", s.putDocument(&s.config))
+	mux.HandleFunc("GET /api/camera/zones", s.getDocument(&s.zones))
+	mux.HandleFunc("POST /api/camera/zones", s.putDocument(&s.zones))
+	mux.HandleFunc("POST /api/camera/test/droidcam", s.setupDroidCam)
+	mux.HandleFunc("POST /api/camera/test/rtsp", s.setupRTSP)
+	mux.HandleFunc("GET /api/camera/snapshot", s.snapshot)
+	mux.HandleFunc("GET /api/camera/stream", s.stream)
+	mux.HandleFunc("GET /api/camera/events", s.eventStream)
+	mux.HandleFunc("GET /api/users", s.listUsers)
+	mux.HandleFunc("POST /api/enroll", s.enroll)
+	mux.HandleFunc("POST /api/enroll/capture", s.enrollCapture)
+	mux.HandleFunc("DELETE /api/users/{name}", s.deleteUser)
+	mux.HandleFunc("POST /api/users/{name}/add-sample", s.addSample)
+
+	s.Server = httptest.NewServer(s.middleware(mux))
+	return s
+}
+
+// Close ends open streams and shuts the server down.
+func (s *Server) Close() {
+	s.stopOnce.Do(func() { close(s.done) })
+	s.Server.Close()
+}
+
+// Client returns a client for the server.
+func (s *Server) Client() *facesvc.Client {
+	return facesvc.New(facesvc.Config{BaseURL: s.URL, Token: s.Token})
+}
+
+// Fail makes requests matching pattern, e.g. "POST /api/camera/start",
+// answer status and body until Recover is called.
+func (s *Server) Fail(pattern string, status int, body interface{}) {
+	s.mu.Lock()
+	s.failures[pattern] = failure{status: status, body: body}
+	s.mu.Unlock()
+}
+
+// Recover undoes Fail for pattern.
+func (s *Server) Recover(pattern string) {
+	s.mu.Lock()
+	delete(s.failures, pattern)
+	s.mu.Unlock()
+}
+
+// SetFrame replaces the JPEG frame served by the snapshot and stream
+// endpoints.
+func (s *Server) SetFrame(frame []byte) {
+	s.mu.Lock()
+	s.frame = frame
+	s.mu.Unlock()
+}
+
+// SendEvent sends an SSE event with data encoded as JSON to every
+// connected event stream.
+func (s *Server) SendEvent(event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	var block bytes.Buffer
+	if event != "" {
+		fmt.Fprintf(&block, "event: %s\n", event)
+	}
+	fmt.Fprintf(&block, "data: %s\n\n", payload)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.events {
+		select {
+		case ch <- block.Bytes():
+		default:
+		}
+	}
+	return nil
+}
+
+// Users returns the enrolled faces and their sample counts.
+func (s *Server) Users() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := make(map[string]int, len(s.users))
+	for name, samples := range s.users {
+		users[name] = samples
+	}
+	return users
+}
+
+// Running reports whether the camera was started.
+func (s *Server) Running() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running
+}
+
+func (s *Server) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Token != "" && r.Header.Get("Authorization") != "Bearer "+s.Token {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid token"})
+			return
+		}
+		_, pattern := next.(*http.ServeMux).Handler(r)
+		s.mu.Lock()
+		f, failing := s.failures[pattern]
+		s.mu.Unlock()
+		if failing {
+			writeJSON(w, f.status, f.body)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"running": s.running, "enrolled_users": len(s.users)})
+}
+
+func (s *Server) setRunning(running bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.running = running
+		s.mu.Unlock()
+		state := "stopped"
+		if running {
+			state = "started"
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"success": true, "message": "Camera " + state})
+	}
+}
+
+func (s *Server) getDocument(doc *json.RawMessage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(*doc)
+	}
+}
+
+func (s *Server) putDocument(doc *json.RawMessage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON"})
+			return
+		}
+		s.mu.Lock()
+		*doc = body
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+	}
+}
+
+func (s *Server) setupDroidCam(w http.ResponseWriter, r *http.Request) {
+	var req facesvc.DroidCamRequest
+	if json.NewDecoder(r.Body).Decode(&req) != nil || req.IP == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "ip is required"})
+		return
+	}
+	if req.Port == 0 {
+		req.Port = 4747
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"success": true, "source": "http://" + req.IP + ":" + strconv.Itoa(req.Port) + "/video"})
+}
+
+func (s *Server) setupRTSP(w http.ResponseWriter, r *http.Request) {
+	var req facesvc.RTSPRequest
+	if json.NewDecoder(r.Body).Decode(&req) != nil || req.URL == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "url is required"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"success": true, "source": req.URL})
+}
+
+func (s *Server) snapshot(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	frame := s.frame
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Write(frame)
+}
+
+func (s *Server) stream(w http.ResponseWriter, r *http.Request) {
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mw.Boundary())
+	ticker := time.NewTicker(frameInterval)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		frame := s.frame
+		s.mu.Unlock()
+		part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"image/jpeg"}})
+		if err != nil {
+			return
+		}
+		if _, err := part.Write(frame); err != nil {
+			return
+		}
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) eventStream(w http.ResponseWriter, r *http.Request) {
+	ch := make(chan []byte, 16)
+	s.mu.Lock()
+	s.events[ch] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.events, ch)
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		case block := <-ch:
+			if _, err := w.Write(block); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+func (s *Server) listUsers(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	users := make([]facesvc.FaceUser, 0, len(s.users))
+	for name, samples := range s.users {
+		users = append(users, facesvc.FaceUser{Name: name, SampleCount: samples})
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, facesvc.UsersResponse{Users: users})
+}
+
+func (s *Server) enroll(w http.ResponseWriter, r *http.Request) {
+	var req facesvc.EnrollRequest
+	if json.NewDecoder(r.Body).Decode(&req) != nil || req.Name == "" || len(req.Images) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name and images are required"})
+		return
+	}
+	s.addUser(w, req.Name, len(req.Images))
+}
+
+func (s *Server) enrollCapture(w http.ResponseWriter, r *http.Request) {
+	var req facesvc.CaptureEnrollRequest
+	if json.NewDecoder(r.Body).Decode(&req) != nil || req.Name == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name is required"})
+		return
+	}
+	s.addUser(w, req.Name, 1)
+}
+
+func (s *Server) addUser(w http.ResponseWriter, name string, samples int) {
+	s.mu.Lock()
+	_, exists := s.users[name]
+	if !exists {
+		s.users[name] = samples
+	}
+	s.mu.Unlock()
+	if exists {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "user " + name + " already exists"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"success": true, "name": name, "sample_count": samples})
+}
+
+func (s *Server) deleteUser(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	s.mu.Lock()
+	_, exists := s.users[name]
+	delete(s.users, name)
+	s.mu.Unlock()
+	if !exists {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "user " + name + " not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"success": true, "message": "User " + name + " deleted"})
+}
+
+func (s *Server) addSample(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	var req facesvc.AddSampleRequest
+	if json.NewDecoder(r.Body).Decode(&req) != nil || req.Image == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "image is required"})
+		return
+	}
+	s.mu.Lock()
+	samples, exists := s.users[name]
+	if exists {
+		samples++
+		s.users[name] = samples
+	}
+	s.mu.Unlock()
+	if !exists {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "user " + name + " not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"success": true, "sample_count": samples})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func grayFrame() []byte {
+	img := image.NewGray(image.Rect(0, 0, 64, 48))
+	for i := range img.Pix {
+		img.Pix[i] = color.Gray{Y: 128}.Y
+	}
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, img, nil)
+	return buf.Bytes()
+}
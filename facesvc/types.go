@@ -0,0 +1,74 @@
+package facesvc
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Result is the JSON object the service answers to a request. Its fields
+// differ per endpoint and service version, so it is kept whole and relayed to
+// API clients as is.
+type Result map[string]interface{}
+
+// Message returns the "message" field, if any.
+func (r Result) Message() string {
+	msg, _ := r["message"].(string)
+	return msg
+}
+
+// ErrorMessage returns the "error" field, if any.
+func (r Result) ErrorMessage() string {
+	msg, _ := r["error"].(string)
+	return msg
+}
+
+// Document is a JSON document owned by the service, such as the camera
+// configuration or the detection zones. The backend passes it through
+// without interpreting it.
+type Document = json.RawMessage
+
+// Stream is an open MJPEG or event stream. Close it when done.
+type Stream struct {
+	io.ReadCloser
+	ContentType string
+}
+
+// FaceUser is an enrolled face.
+type FaceUser struct {
+	Name        string `json:"name"`
+	SampleCount int    `json:"sample_count"`
+}
+
+// UsersResponse is the answer of GET /api/users.
+type UsersResponse struct {
+	Users []FaceUser `json:"users"`
+}
+
+// EnrollRequest registers a face from base64 encoded images.
+type EnrollRequest struct {
+	Name   string   `json:"name" binding:"required"`
+	Role   string   `json:"role,omitempty"`
+	Images []string `json:"images" binding:"required,min=1"`
+}
+
+// CaptureEnrollRequest registers a face from the current camera frame.
+type CaptureEnrollRequest struct {
+	Name string `json:"name" binding:"required"`
+	Role string `json:"role,omitempty"`
+}
+
+// AddSampleRequest adds one base64 encoded image to an enrolled face.
+type AddSampleRequest struct {
+	Image string `json:"image" binding:"required"`
+}
+
+// DroidCamRequest points the camera at a DroidCam phone.
+type DroidCamRequest struct {
+	IP   string `json:"ip" binding:"required"`
+	Port int    `json:"port,omitempty"`
+}
+
+// RTSPRequest points the camera at an RTSP stream.
+type RTSPRequest struct {
+	URL string `json:"url" binding:"required"`
+}
@@ -5,7 +5,15 @@ import (
 	"comproBackend/routes"
 	"comproBackend/services"
 	"comproBackend/utils"
+	"context"
+	"encoding/json"
+	"errors"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -18,12 +26,35 @@ func main() {
 		log.Println("Warning: .env file not found, using system environment variables")
 	}
 
+	// Load site timezone used for day boundaries and local timestamps
+	config.LoadSiteTimezone()
+
+	// Load the key that signs log chain checkpoints and tombstones
+	if err := services.LoadSigningKey(); err != nil {
+		log.Fatal("Failed to load log signing key:", err)
+	}
+
 	// Initialize database
 	config.ConnectDatabase()
 
+	// `go run . verify-chain` checks the log hash chain and exits
+	if len(os.Args) > 1 && os.Args[1] == "verify-chain" {
+		verifyChain()
+		return
+	}
+
 	// Seed UAT test users
 	services.SeedUATUsers()
 
+	// Purge logs past their retention period in the background
+	services.StartRetentionWorker()
+
+	// Sign the log chain head periodically
+	services.StartCheckpointWorker()
+
+	// Store snapshots and clips for new logs
+	services.StartEvidenceCapture()
+
 	// Initialize Firebase for push notifications
 	if err := services.InitFirebase(); err != nil {
 		log.Printf("Warning: Failed to initialize Firebase: %v", err)
@@ -32,6 +63,12 @@ func main() {
 	// Start WebSocket manager for broadcasting events
 	go utils.Manager.Start()
 
+	// Log and broadcast camera service events, watched or not
+	services.StartCameraEventConsumer(utils.PublishCameraEvent)
+
+	// Learn access patterns and raise anomalies for new logs
+	services.StartAnomalyDetector(utils.Publish)
+
 	// Create Gin router
 	r := gin.Default()
 
@@ -51,7 +88,37 @@ func main() {
 	routes.SetupRoutes(r)
 
 	// Start server
-	if err := r.Run("192.168.18.8:8080"); err != nil {
-		log.Fatal("Failed to start server:", err)
+	srv := &http.Server{Addr: "192.168.18.8:8080", Handler: r}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("Failed to start server:", err)
+		}
+	}()
+
+	// Shut down gracefully: close WebSocket clients first, since hijacked
+	// connections are not tracked by Shutdown
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	log.Println("Shutting down server...")
+
+	utils.Manager.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Println("Server shutdown error:", err)
+	}
+}
+
+func verifyChain() {
+	report, err := services.VerifyLogChain()
+	if err != nil {
+		log.Fatal("Failed to verify log chain:", err)
+	}
+
+	out, _ := json.MarshalIndent(report, "", "  ")
+	os.Stdout.Write(append(out, '\n'))
+	if !report.OK {
+		os.Exit(1)
 	}
 }
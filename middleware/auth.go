@@ -3,6 +3,7 @@ package middleware
 import (
 	"comproBackend/config"
 	"comproBackend/models"
+	"errors"
 	"net/http"
 	"os"
 	"strings"
@@ -28,6 +29,46 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
+// Identity is the caller behind a request or WebSocket connection. User is
+// nil for the service token.
+type Identity struct {
+	UserID   uint
+	Username string
+	Role     string
+	User     *models.User
+}
+
+var (
+	ErrInvalidToken = errors.New("Invalid or expired token")
+	ErrUserNotFound = errors.New("User not found")
+)
+
+// Authenticate resolves a bearer token, either SERVICE_AUTH_TOKEN or a login
+// JWT whose user still exists.
+func Authenticate(tokenString string) (Identity, error) {
+	// Check for service token (internal service-to-service auth)
+	serviceToken := os.Getenv("SERVICE_AUTH_TOKEN")
+	if serviceToken != "" && tokenString == serviceToken {
+		return Identity{Username: "face_lock_service", Role: "service"}, nil
+	}
+
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return JWTSecret, nil
+	})
+
+	if err != nil || !token.Valid {
+		return Identity{}, ErrInvalidToken
+	}
+
+	var user models.User
+	if err := config.DB.First(&user, claims.UserID).Error; err != nil {
+		return Identity{}, ErrUserNotFound
+	}
+
+	return Identity{UserID: claims.UserID, Username: claims.Username, Role: claims.Role, User: &user}, nil
+}
+
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
@@ -44,39 +85,19 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		tokenString := parts[1]
-
-		// Check for service token (internal service-to-service auth)
-		serviceToken := os.Getenv("SERVICE_AUTH_TOKEN")
-		if serviceToken != "" && tokenString == serviceToken {
-			c.Set("username", "face_lock_service")
-			c.Set("role", "service")
-			c.Next()
-			return
-		}
-
-		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return JWTSecret, nil
-		})
-
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		identity, err := Authenticate(parts[1])
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 			c.Abort()
 			return
 		}
 
-		var user models.User
-		if err := config.DB.First(&user, claims.UserID).Error; err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
-			c.Abort()
-			return
+		if identity.User != nil {
+			c.Set("user", *identity.User)
+			c.Set("user_id", identity.UserID)
 		}
-
-		c.Set("user", user)
-		c.Set("user_id", claims.UserID)
-		c.Set("username", claims.Username)
-		c.Set("role", claims.Role)
+		c.Set("username", identity.Username)
+		c.Set("role", identity.Role)
 		c.Next()
 	}
 }
@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// Review states of a log. Every log starts unreviewed.
+const (
+	ReviewUnreviewed    = "unreviewed"
+	ReviewReviewed      = "reviewed"
+	ReviewFalsePositive = "false_positive"
+	ReviewIncident      = "incident"
+)
+
+// ReviewStatuses lists the valid values of Log.ReviewStatus.
+var ReviewStatuses = []string{ReviewUnreviewed, ReviewReviewed, ReviewFalsePositive, ReviewIncident}
+
+// LogNote is a free text note a user attached to a log.
+type LogNote struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	LogID     uint      `gorm:"not null;index" json:"log_id"`
+	Username  string    `gorm:"size:255;not null" json:"username"`
+	Body      string    `gorm:"type:text;not null" json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (LogNote) TableName() string {
+	return "log_notes"
+}
+
+// LogTag is one tag on a log. Tags are lower case.
+type LogTag struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	LogID     uint      `gorm:"not null;uniqueIndex:idx_log_tags_log_tag,priority:1" json:"log_id"`
+	Tag       string    `gorm:"size:50;not null;uniqueIndex:idx_log_tags_log_tag,priority:2;index" json:"tag"`
+	CreatedBy string    `gorm:"size:255;not null" json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (LogTag) TableName() string {
+	return "log_tags"
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Anomaly kinds raised by the access pattern analyzer.
+const (
+	AnomalyUnusualHour    = "unusual_hour"
+	AnomalyUnknownBurst   = "unknown_burst"
+	AnomalyConfidenceDrop = "confidence_drop"
+)
+
+// Anomaly is something unusual found in the access logs. Score runs from 0
+// (barely unusual) to 1.
+type Anomaly struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	Kind        string     `gorm:"size:30;not null;index" json:"kind"`
+	Name        string     `gorm:"size:255;not null;default:'';index" json:"name,omitempty"`
+	LogID       *uint      `gorm:"index" json:"log_id,omitempty"`
+	Score       float64    `gorm:"not null" json:"score"`
+	Explanation string     `gorm:"type:text;not null" json:"explanation"`
+	OccurredAt  time.Time  `gorm:"type:datetime(3);index" json:"occurred_at"`
+	Status      string     `gorm:"size:20;not null;default:'open';index" json:"status"` // open | acknowledged | dismissed
+	ReviewedBy  string     `gorm:"size:255;not null;default:''" json:"reviewed_by,omitempty"`
+	ReviewedAt  *time.Time `gorm:"type:datetime(3)" json:"reviewed_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+func (Anomaly) TableName() string {
+	return "anomalies"
+}
+
+// IdentityBaseline is the learned normal behaviour of one identity: when they
+// usually arrive and how confidently they are recognized.
+type IdentityBaseline struct {
+	Name           string    `gorm:"primaryKey;size:255" json:"name"`
+	Samples        int64     `gorm:"not null" json:"samples"`
+	Hours          [24]int64 `gorm:"-" json:"hours"` // detections per local hour
+	HourCounts     string    `gorm:"type:text;not null" json:"-"`
+	ConfidenceMean float64   `gorm:"not null" json:"confidence_mean"`
+	ConfidenceStd  float64   `gorm:"not null" json:"confidence_std"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+func (IdentityBaseline) TableName() string {
+	return "identity_baselines"
+}
+
+func (b *IdentityBaseline) BeforeSave(tx *gorm.DB) error {
+	data, err := json.Marshal(b.Hours)
+	b.HourCounts = string(data)
+	return err
+}
+
+func (b *IdentityBaseline) AfterFind(tx *gorm.DB) error {
+	if b.HourCounts == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(b.HourCounts), &b.Hours)
+}
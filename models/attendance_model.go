@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// AttendanceMember is a person expected at the office. Name matches the
+// identity name in logs; Team groups members in reports.
+type AttendanceMember struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"size:255;not null;uniqueIndex" json:"name"`
+	Team      string    `gorm:"size:100;not null;default:'';index" json:"team"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (AttendanceMember) TableName() string {
+	return "attendance_members"
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// LogEvidence is a stored image or clip captured when a log was created. The
+// file itself lives in the blob store under BlobKey.
+type LogEvidence struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	LogID       uint      `gorm:"not null;uniqueIndex:idx_log_evidence_log_kind,priority:1" json:"log_id"`
+	Kind        string    `gorm:"size:20;not null;uniqueIndex:idx_log_evidence_log_kind,priority:2" json:"kind"` // snapshot | thumbnail | clip
+	BlobKey     string    `gorm:"size:255;not null" json:"-"`
+	ContentType string    `gorm:"size:100;not null" json:"content_type"`
+	Size        int64     `gorm:"not null" json:"size"`
+	SHA256      string    `gorm:"size:64;not null" json:"sha256"`
+	Frames      int       `gorm:"not null;default:0" json:"frames,omitempty"`
+	CapturedAt  time.Time `gorm:"type:datetime(3)" json:"captured_at"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (LogEvidence) TableName() string {
+	return "log_evidence"
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// LogExport is the audit record of a log export request.
+type LogExport struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"index" json:"user_id"`
+	Username   string     `gorm:"size:255;not null" json:"username"`
+	Format     string     `gorm:"size:10;not null" json:"format"`
+	Query      string     `gorm:"type:text" json:"query"`
+	RangeStart time.Time  `gorm:"type:datetime(3)" json:"range_start"`
+	RangeEnd   time.Time  `gorm:"type:datetime(3)" json:"range_end"`
+	RowCount   int64      `gorm:"not null;default:0" json:"row_count"`
+	Status     string     `gorm:"size:20;not null" json:"status"` // started | completed | failed
+	Error      string     `gorm:"type:text" json:"error,omitempty"`
+	FinishedAt *time.Time `json:"finished_at"`
+	CreatedAt  time.Time  `gorm:"index" json:"created_at"`
+}
+
+func (LogExport) TableName() string {
+	return "log_exports"
+}
@@ -0,0 +1,142 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LogChainHead is a single-row table holding the end of the log hash chain.
+// Appends lock it with SELECT ... FOR UPDATE so concurrent inserts, even from
+// different backend instances, extend the chain one at a time.
+type LogChainHead struct {
+	ID   uint   `gorm:"primaryKey"`
+	Seq  uint64 `gorm:"not null;default:0"`
+	Hash string `gorm:"size:64;not null;default:''"`
+	// BackfillPending is set while the logs written before the chain existed
+	// are being appended. Once cleared, unchained logs are never appended
+	// again; verification reports them instead.
+	BackfillPending bool `gorm:"not null;default:false"`
+	UpdatedAt       time.Time
+}
+
+func (LogChainHead) TableName() string {
+	return "log_chain_heads"
+}
+
+// LogCheckpoint is a server-signed statement that the chain had a given hash
+// at a given sequence number.
+type LogCheckpoint struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Seq       uint64    `gorm:"not null;index" json:"seq"`
+	Hash      string    `gorm:"size:64;not null" json:"hash"`
+	KeyID     string    `gorm:"size:16;not null" json:"key_id"`
+	Signature string    `gorm:"size:128;not null" json:"signature"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (LogCheckpoint) TableName() string {
+	return "log_checkpoints"
+}
+
+// LogTombstone records a run of consecutive chain entries that were removed
+// on purpose (retention or purge), so the chain still verifies across the gap.
+type LogTombstone struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	FromSeq   uint64    `gorm:"not null;uniqueIndex" json:"from_seq"`
+	ToSeq     uint64    `gorm:"not null" json:"to_seq"`
+	PrevHash  string    `gorm:"size:64;not null" json:"prev_hash"`
+	LastHash  string    `gorm:"size:64;not null" json:"last_hash"`
+	Count     int       `gorm:"not null" json:"count"`
+	Reason    string    `gorm:"size:50;not null" json:"reason"`
+	KeyID     string    `gorm:"size:16;not null" json:"key_id"`
+	Signature string    `gorm:"size:128;not null" json:"signature"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (LogTombstone) TableName() string {
+	return "log_tombstones"
+}
+
+// chainContent is the hashed form of a log. Only detection facts are covered;
+// review state and soft deletion can change without breaking the chain.
+type chainContent struct {
+	Seq        uint64  `json:"seq"`
+	ID         uint    `json:"id"`
+	OccurredAt int64   `json:"occurred_at"`
+	Name       string  `json:"name"`
+	Role       string  `json:"role"`
+	Authorized bool    `json:"authorized"`
+	Confidence float64 `json:"confidence"`
+	CameraID   string  `json:"camera_id"`
+	Door       string  `json:"door"`
+	Timestamp  string  `json:"timestamp"`
+	PrevHash   string  `json:"prev_hash"`
+}
+
+// ChainHash returns the SHA-256 of the log content at position seq, linked to
+// the hash of the previous entry.
+func (l *Log) ChainHash(seq uint64, prevHash string) string {
+	data, _ := json.Marshal(chainContent{
+		Seq:        seq,
+		ID:         l.ID,
+		OccurredAt: l.OccurredAt.UnixMilli(),
+		Name:       l.Name,
+		Role:       l.Role,
+		Authorized: l.Authorized,
+		Confidence: l.Confidence,
+		CameraID:   l.CameraID,
+		Door:       l.Door,
+		Timestamp:  l.Timestamp,
+		PrevHash:   prevHash,
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// BeforeCreate drops any chain fields sent by a client; they are assigned in
+// AfterCreate.
+func (l *Log) BeforeCreate(tx *gorm.DB) error {
+	l.ChainSeq = nil
+	l.PrevHash = ""
+	l.Hash = ""
+	return nil
+}
+
+// AfterCreate links every new log into the hash chain inside the insert
+// transaction.
+func (l *Log) AfterCreate(tx *gorm.DB) error {
+	return AppendToChain(tx.Session(&gorm.Session{NewDB: true}), l)
+}
+
+// AppendToChain assigns the next sequence number to l and stores its hash.
+// tx must be a transaction; the chain head row stays locked until it ends.
+func AppendToChain(tx *gorm.DB, l *Log) error {
+	var head LogChainHead
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&head, 1).Error; err != nil {
+		return err
+	}
+
+	seq := head.Seq + 1
+	hash := l.ChainHash(seq, head.Hash)
+
+	if err := tx.Model(&Log{}).Unscoped().Where("id = ?", l.ID).UpdateColumns(map[string]interface{}{
+		"chain_seq": seq,
+		"prev_hash": head.Hash,
+		"hash":      hash,
+	}).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&head).Updates(map[string]interface{}{"seq": seq, "hash": hash}).Error; err != nil {
+		return err
+	}
+
+	l.ChainSeq = &seq
+	l.PrevHash = head.Hash
+	l.Hash = hash
+	return nil
+}
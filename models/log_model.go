@@ -1,20 +1,110 @@
 package models
 
 import (
+	"fmt"
+	"strings"
 	"time"
+
+	"gorm.io/gorm"
 )
 
 type Log struct {
-	ID         uint      `gorm:"primaryKey" json:"id"`
-	Authorized bool      `gorm:"not null" json:"authorized"`
-	Confidence float64   `gorm:"not null" json:"confidence"`
-	Name       string    `gorm:"not null" json:"name"`
-	Role       string    `gorm:"not null" json:"role"`
-	Timestamp  string    `gorm:"not null" json:"timestamp"`
-	CreatedAt  time.Time `json:"-"`
-	UpdatedAt  time.Time `json:"-"`
+	ID           uint           `gorm:"primaryKey" json:"id"`
+	EventID      *string        `gorm:"size:100;uniqueIndex" json:"event_id,omitempty"`
+	Authorized   bool           `gorm:"not null;index" json:"authorized"`
+	Confidence   float64        `gorm:"not null" json:"confidence"`
+	Name         string         `gorm:"size:255;not null;index;index:idx_logs_occurred_at_name,priority:2" json:"name"`
+	Role         string         `gorm:"size:100;not null;index" json:"role"`
+	CameraID     string         `gorm:"size:100;not null;default:'';index" json:"camera_id"`
+	Door         string         `gorm:"size:100;not null;default:'';index" json:"door"`
+	OccurredAt   time.Time      `gorm:"type:datetime(3);index;index:idx_logs_occurred_at_name,priority:1" json:"occurred_at"`
+	Timestamp    string         `gorm:"not null" json:"timestamp"` // Deprecated: kept for older clients, use OccurredAt
+	ChainSeq     *uint64        `gorm:"uniqueIndex" json:"chain_seq"`
+	PrevHash     string         `gorm:"size:64;not null;default:''" json:"prev_hash"`
+	Hash         string         `gorm:"size:64;not null;default:''" json:"hash"`
+	ReviewStatus string         `gorm:"size:20;not null;default:'unreviewed';index" json:"review_status"`
+	ReviewedBy   string         `gorm:"size:255;not null;default:''" json:"reviewed_by,omitempty"`
+	ReviewedAt   *time.Time     `gorm:"type:datetime(3)" json:"reviewed_at,omitempty"`
+	Tags         []string       `gorm:"-" json:"tags,omitempty"`
+	CreatedAt    time.Time      `json:"-"`
+	UpdatedAt    time.Time      `json:"-"`
+	DeletedAt    gorm.DeletedAt `gorm:"index" json:"deleted_at"`
+	DeletedBy    string         `gorm:"size:255;not null;default:''" json:"deleted_by,omitempty"`
 }
 
 func (Log) TableName() string {
 	return "logs"
 }
+
+// NaiveTimestampLocation is the zone assumed for legacy timestamps that carry
+// no UTC offset, e.g. "2025-12-18T07:30:00".
+var NaiveTimestampLocation = loadLocation("Asia/Jakarta", 7*60*60)
+
+// timestampLayouts lists every format the Python service and older clients
+// have been known to send, most specific first.
+var timestampLayouts = []struct {
+	layout string
+	naive  bool
+}{
+	{time.RFC3339Nano, false},
+	{"2006-01-02T15:04:05.999999999Z0700", false},
+	{"2006-01-02 15:04:05.999999999Z07:00", false},
+	{"2006-01-02T15:04:05.999999999", true},
+	{"2006-01-02 15:04:05.999999999", true},
+	{"2006-01-02T15:04", true},
+	{"2006-01-02 15:04", true},
+}
+
+// ParseTimestamp parses any of the accepted log timestamp formats and returns
+// the instant in UTC. Timestamps without an offset are read in loc.
+func ParseTimestamp(value string, loc *time.Location) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, f := range timestampLayouts {
+		var t time.Time
+		var err error
+		if f.naive {
+			t, err = time.ParseInLocation(f.layout, value, loc)
+		} else {
+			t, err = time.Parse(f.layout, value)
+		}
+		if err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized timestamp %q, use RFC3339", value)
+}
+
+// BeforeSave keeps OccurredAt and the legacy Timestamp string in sync so every
+// insert path stores a UTC instant.
+func (l *Log) BeforeSave(tx *gorm.DB) error {
+	if l.OccurredAt.IsZero() {
+		if l.Timestamp == "" {
+			l.OccurredAt = time.Now()
+		} else {
+			t, err := ParseTimestamp(l.Timestamp, NaiveTimestampLocation)
+			if err != nil {
+				return err
+			}
+			l.OccurredAt = t
+		}
+	}
+
+	// datetime(3) rounds, so truncate here to keep cursors exact
+	l.OccurredAt = l.OccurredAt.UTC().Truncate(time.Millisecond)
+
+	if l.Timestamp == "" {
+		l.Timestamp = l.OccurredAt.Format(time.RFC3339)
+	}
+	if l.ReviewStatus == "" {
+		l.ReviewStatus = ReviewUnreviewed
+	}
+	return nil
+}
+
+func loadLocation(name string, fallbackOffset int) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.FixedZone(name, fallbackOffset)
+	}
+	return loc
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// WsBroadcast is an event passed between backend instances by the database
+// backplane. Its ID is the event's sequence number on every instance.
+type WsBroadcast struct {
+	ID        uint64    `gorm:"primaryKey"`
+	Type      string    `gorm:"size:50;not null"`
+	Envelope  string    `gorm:"type:mediumtext;not null"` // without seq
+	Legacy    string    `gorm:"type:mediumtext;not null"`
+	CreatedAt time.Time `gorm:"index"`
+}
+
+func (WsBroadcast) TableName() string {
+	return "ws_broadcasts"
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// WsEvent is a journaled WebSocket event, kept so clients can resume a
+// session after a restart.
+type WsEvent struct {
+	Seq       uint64    `gorm:"primaryKey;autoIncrement:false"`
+	Type      string    `gorm:"size:50;not null"`
+	Topic     string    `gorm:"size:30;not null"`
+	CameraID  string    `gorm:"size:100;not null;default:''"`
+	Name      string    `gorm:"size:255;not null;default:''"`
+	Envelope  string    `gorm:"type:mediumtext;not null"`
+	Legacy    string    `gorm:"type:mediumtext;not null"`
+	CreatedAt time.Time `gorm:"index"`
+}
+
+func (WsEvent) TableName() string {
+	return "ws_events"
+}
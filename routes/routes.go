@@ -30,9 +30,55 @@ func SetupRoutes(r *gin.Engine) {
 		{
 			protected.GET("", controllers.GetLogs)
 			protected.GET("/filter", controllers.GetFilteredLogs) // query : period=today|date|range&date=YYYY-MM-DD&start=YYYY-MM-DD&end=YYYY-MM-DD&name=
+			protected.GET("/stats", controllers.GetLogStats)      // same query as /filter, plus from/to (RFC3339)
+			protected.GET("/export", controllers.ExportLogs)      // same query as /filter, plus format=csv|xlsx|pdf
+			protected.GET("/exports", controllers.GetLogExports)
+			protected.GET("/stream", controllers.StreamLogs) // SSE, same query as /filter (period optional), Last-Event-ID replay
+			protected.GET("/tags", controllers.GetLogTags)
+			protected.GET("/retention", controllers.GetRetentionPreview) // dry run
+			protected.POST("/retention/run", controllers.RunRetentionPurge)
+			protected.GET("/chain/verify", controllers.VerifyLogChain)
+			protected.GET("/chain/checkpoints", controllers.GetLogCheckpoints)
+			protected.POST("/chain/checkpoints", controllers.CreateLogCheckpoint)
 
-			protected.POST("", controllers.CreateLog)
-			protected.DELETE("/:id", controllers.DeleteLog)
+			protected.GET("/trash", controllers.GetTrashedLogs)
+			protected.POST("/bulk/delete", controllers.BulkDeleteLogs)   // body: ids or /filter query, then confirmation_token
+			protected.POST("/bulk/restore", controllers.BulkRestoreLogs) // same as bulk delete, on trashed logs
+
+			protected.POST("", controllers.CreateLog)            // optional Idempotency-Key header
+			protected.POST("/batch", controllers.CreateLogBatch) // per-item results, no notifications
+			protected.DELETE("/:id", controllers.DeleteLog)      // soft delete
+			protected.POST("/:id/restore", controllers.RestoreLog)
+			protected.GET("/:id/evidence", controllers.GetLogEvidence)
+			protected.PATCH("/:id/review", controllers.ReviewLog) // status: unreviewed|reviewed|false_positive|incident
+			protected.GET("/:id/notes", controllers.GetLogNotes)
+			protected.POST("/:id/notes", controllers.AddLogNote)
+			protected.PUT("/:id/tags", controllers.SetLogTags)
+			protected.GET("/:id/evidence/:kind", controllers.GetLogEvidenceFile) // kind: snapshot|thumbnail|clip
+			protected.DELETE("/:id/purge", controllers.PurgeLog)                 // verificator only, log must be in trash
+		}
+
+		reports := v1.Group("/reports")
+		reports.Use(middleware.AuthMiddleware())
+		{
+			reports.GET("/attendance", controllers.GetAttendanceReport) // /filter period query, view=daily|person|team, format=json|csv
+			reports.GET("/attendance/members", controllers.GetAttendanceMembers)
+			reports.POST("/attendance/members", controllers.SaveAttendanceMember)         // verificator only
+			reports.DELETE("/attendance/members/:id", controllers.DeleteAttendanceMember) // verificator only
+		}
+
+		search := v1.Group("/search")
+		search.Use(middleware.AuthMiddleware())
+		{
+			search.GET("", controllers.Search) // query: q, type=log,user,face, limit
+		}
+
+		anomalies := v1.Group("/anomalies")
+		anomalies.Use(middleware.AuthMiddleware())
+		{
+			anomalies.GET("", controllers.GetAnomalies) // query: kind, status, name, limit
+			anomalies.GET("/baselines", controllers.GetIdentityBaselines)
+			anomalies.PATCH("/:id", controllers.ReviewAnomaly) // status: open|acknowledged|dismissed
 		}
 
 		camera := v1.Group("/camera")
@@ -61,7 +107,8 @@ func SetupRoutes(r *gin.Engine) {
 		}
 	}
 
-	v1.GET("/ws", utils.WsHandler)
+	v1.GET("/ws", utils.WsHandler) // Authorization header, "bearer, <token>" subprotocol or ?ticket=
+	v1.POST("/ws/ticket", middleware.AuthMiddleware(), controllers.CreateWsTicket)
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "ok"})
 	})
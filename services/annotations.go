@@ -0,0 +1,21 @@
+package services
+
+import (
+	"comproBackend/config"
+	"comproBackend/models"
+	"log"
+)
+
+// DeleteLogAnnotations removes the notes and tags of permanently deleted
+// logs.
+func DeleteLogAnnotations(logIDs []uint) {
+	if len(logIDs) == 0 {
+		return
+	}
+	if err := config.DB.Where("log_id IN ?", logIDs).Delete(&models.LogNote{}).Error; err != nil {
+		log.Printf("[ANNOTATIONS] Failed to delete notes of deleted logs: %v", err)
+	}
+	if err := config.DB.Where("log_id IN ?", logIDs).Delete(&models.LogTag{}).Error; err != nil {
+		log.Printf("[ANNOTATIONS] Failed to delete tags of deleted logs: %v", err)
+	}
+}
@@ -0,0 +1,389 @@
+package services
+
+import (
+	"comproBackend/config"
+	"comproBackend/events"
+	"comproBackend/models"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	baselineRefresh   = time.Hour
+	unusualHourShare  = 0.02 // visits in the ±1h window below this share are unusual
+	confidenceSamples = 5
+	minConfidenceStd  = 0.02
+	anomalyZThreshold = 3
+)
+
+// AnomalyConfig controls the access pattern analyzer.
+type AnomalyConfig struct {
+	Interval     time.Duration
+	BaselineDays int
+	MinSamples   int64
+	BurstWindow  time.Duration
+	BurstMin     int64
+}
+
+// LoadAnomalyConfig reads the ANOMALY_* environment variables.
+func LoadAnomalyConfig() AnomalyConfig {
+	cfg := AnomalyConfig{
+		Interval:     envDuration("ANOMALY_INTERVAL", 5*time.Minute),
+		BaselineDays: envInt("ANOMALY_BASELINE_DAYS", 30),
+		MinSamples:   int64(envInt("ANOMALY_MIN_SAMPLES", 20)),
+		BurstWindow:  envDuration("ANOMALY_BURST_WINDOW", 10*time.Minute),
+		BurstMin:     int64(envInt("ANOMALY_BURST_MIN", 5)),
+	}
+	if cfg.BaselineDays < 1 {
+		cfg.BaselineDays = 30
+	}
+	if cfg.BurstWindow <= 0 {
+		cfg.BurstWindow = 10 * time.Minute
+	}
+	return cfg
+}
+
+// StartAnomalyDetector analyzes new logs every ANOMALY_INTERVAL (0 disables
+// it). Anomalies are stored, passed to broadcast and pushed to every device.
+// Logs that already exist at startup are only used as baseline.
+func StartAnomalyDetector(broadcast func(events.Envelope)) {
+	cfg := LoadAnomalyConfig()
+	if cfg.Interval == 0 {
+		log.Println("[ANOMALY] Anomaly detection disabled")
+		return
+	}
+
+	d := &anomalyDetector{cfg: cfg, broadcast: broadcast}
+	if err := config.DB.Unscoped().Model(&models.Log{}).Select("COALESCE(MAX(id), 0)").Scan(&d.lastLogID).Error; err != nil {
+		log.Printf("Warning: anomaly detection disabled: %v", err)
+		return
+	}
+	log.Printf("[ANOMALY] Checking new logs every %s against %d day baselines", cfg.Interval, cfg.BaselineDays)
+
+	go func() {
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		for {
+			d.run(time.Now())
+			<-ticker.C
+		}
+	}()
+}
+
+type anomalyDetector struct {
+	cfg              AnomalyConfig
+	broadcast        func(events.Envelope)
+	lastLogID        uint
+	baselines        map[string]models.IdentityBaseline
+	baselinesAt      time.Time
+	unknownPerWindow float64
+}
+
+func (d *anomalyDetector) run(now time.Time) {
+	if d.baselines == nil || now.Sub(d.baselinesAt) >= baselineRefresh {
+		if err := d.refreshBaselines(now); err != nil {
+			log.Printf("[ANOMALY] Baseline refresh failed: %v", err)
+			return
+		}
+	}
+	if err := d.checkNewLogs(now); err != nil {
+		log.Printf("[ANOMALY] Checking new logs failed: %v", err)
+	}
+	if err := d.checkUnknownBurst(now); err != nil {
+		log.Printf("[ANOMALY] Checking unknown faces failed: %v", err)
+	}
+}
+
+// refreshBaselines relearns every identity's arrival hours and recognition
+// confidence from the last BaselineDays of authorized logs.
+func (d *anomalyDetector) refreshBaselines(now time.Time) error {
+	since := now.AddDate(0, 0, -d.cfg.BaselineDays)
+	known := config.DB.Model(&models.Log{}).
+		Where("authorized = ? AND name <> ? AND occurred_at >= ?", true, "Unknown", since)
+
+	var stats []struct {
+		Name           string
+		Samples        int64
+		ConfidenceMean float64
+		ConfidenceStd  float64
+	}
+	if err := known.Session(&gorm.Session{}).
+		Select("name, COUNT(*) AS samples, AVG(confidence) AS confidence_mean, COALESCE(STDDEV_POP(confidence), 0) AS confidence_std").
+		Group("name").Scan(&stats).Error; err != nil {
+		return err
+	}
+
+	// hours are bucketed in UTC by SQL and moved to the site timezone here,
+	// which keeps DST correct without MySQL timezone tables
+	var buckets []struct {
+		Name   string
+		Bucket string
+		Count  int64
+	}
+	if err := known.Session(&gorm.Session{}).
+		Select("name, DATE_FORMAT(occurred_at, '%Y-%m-%d %H:00:00') AS bucket, COUNT(*) AS count").
+		Group("name, bucket").Scan(&buckets).Error; err != nil {
+		return err
+	}
+
+	baselines := make(map[string]models.IdentityBaseline, len(stats))
+	for _, s := range stats {
+		baselines[s.Name] = models.IdentityBaseline{
+			Name:           s.Name,
+			Samples:        s.Samples,
+			ConfidenceMean: s.ConfidenceMean,
+			ConfidenceStd:  s.ConfidenceStd,
+		}
+	}
+	for _, b := range buckets {
+		t, err := time.ParseInLocation("2006-01-02 15:04:05", b.Bucket, time.UTC)
+		if err != nil {
+			continue
+		}
+		baseline := baselines[b.Name]
+		baseline.Hours[t.In(config.SiteLocation).Hour()] += b.Count
+		baselines[b.Name] = baseline
+	}
+
+	var unknown int64
+	if err := config.DB.Model(&models.Log{}).Where("name = ? AND occurred_at >= ?", "Unknown", since).Count(&unknown).Error; err != nil {
+		return err
+	}
+	windows := float64(now.Sub(since)) / float64(d.cfg.BurstWindow)
+	d.unknownPerWindow = float64(unknown) / windows
+
+	if len(baselines) > 0 {
+		list := make([]models.IdentityBaseline, 0, len(baselines))
+		for _, b := range baselines {
+			list = append(list, b)
+		}
+		if err := config.DB.Clauses(clause.OnConflict{UpdateAll: true}).CreateInBatches(&list, 200).Error; err != nil {
+			return err
+		}
+	}
+	if err := config.DB.Where("updated_at < ?", now.Add(-time.Minute)).Delete(&models.IdentityBaseline{}).Error; err != nil {
+		return err
+	}
+
+	d.baselines = baselines
+	d.baselinesAt = now
+	return nil
+}
+
+func (d *anomalyDetector) checkNewLogs(now time.Time) error {
+	for {
+		var logs []models.Log
+		if err := config.DB.Where("id > ?", d.lastLogID).Order("id").Limit(500).Find(&logs).Error; err != nil {
+			return err
+		}
+
+		seen := make(map[string]bool)
+		for _, l := range logs {
+			d.lastLogID = l.ID
+			if !l.Authorized || l.Name == "Unknown" {
+				continue
+			}
+			seen[l.Name] = true
+			d.checkHour(l)
+		}
+		for name := range seen {
+			if err := d.checkConfidence(name, now); err != nil {
+				return err
+			}
+		}
+
+		if len(logs) < 500 {
+			return nil
+		}
+	}
+}
+
+// checkHour flags a known person detected at an hour they almost never
+// appear at.
+func (d *anomalyDetector) checkHour(l models.Log) {
+	b, ok := d.baselines[l.Name]
+	if !ok || b.Samples < d.cfg.MinSamples {
+		return
+	}
+
+	var total int64
+	for _, n := range b.Hours {
+		total += n
+	}
+	local := l.OccurredAt.In(config.SiteLocation)
+	h := local.Hour()
+	window := b.Hours[(h+23)%24] + b.Hours[h] + b.Hours[(h+1)%24]
+	share := float64(window) / float64(total)
+	if total == 0 || share >= unusualHourShare {
+		return
+	}
+
+	logID := l.ID
+	d.raise(models.Anomaly{
+		Kind:  models.AnomalyUnusualHour,
+		Name:  l.Name,
+		LogID: &logID,
+		Score: 1 - share/unusualHourShare,
+		Explanation: fmt.Sprintf("%s was detected at %s; only %d of %d detections in the last %d days were between %02d:00 and %02d:00",
+			l.Name, local.Format("15:04"), window, total, d.cfg.BaselineDays, (h+23)%24, (h+2)%24),
+		OccurredAt: l.OccurredAt,
+	})
+}
+
+// checkConfidence flags an identity whose recent detections are recognized
+// with clearly lower confidence than usual, which hints at spoofing or an
+// outdated enrollment.
+func (d *anomalyDetector) checkConfidence(name string, now time.Time) error {
+	b, ok := d.baselines[name]
+	if !ok || b.Samples < d.cfg.MinSamples {
+		return nil
+	}
+
+	var recent []float64
+	if err := config.DB.Model(&models.Log{}).
+		Where("name = ? AND authorized = ? AND occurred_at >= ?", name, true, now.Add(-24*time.Hour)).
+		Order("id DESC").Limit(confidenceSamples).Pluck("confidence", &recent).Error; err != nil {
+		return err
+	}
+	if len(recent) < confidenceSamples {
+		return nil
+	}
+
+	var sum float64
+	for _, c := range recent {
+		sum += c
+	}
+	mean := sum / float64(len(recent))
+	z := (b.ConfidenceMean - mean) / math.Max(b.ConfidenceStd, minConfidenceStd)
+	if z < anomalyZThreshold {
+		return nil
+	}
+
+	raised, err := anomalyRaisedSince(models.AnomalyConfidenceDrop, name, now.Add(-24*time.Hour))
+	if err != nil || raised {
+		return err
+	}
+
+	d.raise(models.Anomaly{
+		Kind:  models.AnomalyConfidenceDrop,
+		Name:  name,
+		Score: math.Min(1, z/(2*anomalyZThreshold)),
+		Explanation: fmt.Sprintf("The last %d detections of %s averaged %.2f confidence, usually %.2f ± %.2f; the face may be spoofed or the enrollment outdated",
+			len(recent), name, mean, b.ConfidenceMean, b.ConfidenceStd),
+		OccurredAt: now,
+	})
+	return nil
+}
+
+// checkUnknownBurst flags far more unknown faces in the last window than the
+// baseline rate.
+func (d *anomalyDetector) checkUnknownBurst(now time.Time) error {
+	var count int64
+	if err := config.DB.Model(&models.Log{}).
+		Where("name = ? AND occurred_at >= ?", "Unknown", now.Add(-d.cfg.BurstWindow)).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count < d.cfg.BurstMin {
+		return nil
+	}
+
+	z := (float64(count) - d.unknownPerWindow) / math.Sqrt(math.Max(d.unknownPerWindow, 1))
+	if z < anomalyZThreshold {
+		return nil
+	}
+
+	raised, err := anomalyRaisedSince(models.AnomalyUnknownBurst, "", now.Add(-d.cfg.BurstWindow))
+	if err != nil || raised {
+		return err
+	}
+
+	d.raise(models.Anomaly{
+		Kind:  models.AnomalyUnknownBurst,
+		Score: math.Min(1, z/(10/3.0*anomalyZThreshold)),
+		Explanation: fmt.Sprintf("%d unknown faces in the last %s, usually about %.1f",
+			count, d.cfg.BurstWindow, d.unknownPerWindow),
+		OccurredAt: now,
+	})
+	return nil
+}
+
+func anomalyRaisedSince(kind, name string, since time.Time) (bool, error) {
+	var count int64
+	err := config.DB.Model(&models.Anomaly{}).
+		Where("kind = ? AND name = ? AND created_at >= ?", kind, name, since).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// raise stores the anomaly and announces it over WebSocket and push.
+func (d *anomalyDetector) raise(a models.Anomaly) {
+	a.Score = math.Round(a.Score*1000) / 1000
+	a.OccurredAt = a.OccurredAt.UTC()
+	if err := config.DB.Create(&a).Error; err != nil {
+		log.Printf("[ANOMALY] Failed to store %s anomaly: %v", a.Kind, err)
+		return
+	}
+	log.Printf("[ANOMALY] %s (score %.2f): %s", a.Kind, a.Score, a.Explanation)
+
+	if d.broadcast != nil {
+		e := events.New(events.TypeAnomaly, events.SourceAnomaly, a)
+		e.OccurredAt = a.OccurredAt
+		d.broadcast(e)
+	}
+	go func() {
+		data := map[string]string{
+			"type":       "anomaly",
+			"anomaly_id": strconv.FormatUint(uint64(a.ID), 10),
+			"kind":       a.Kind,
+			"name":       a.Name,
+		}
+		if err := SendPushNotificationToAllUsers("Unusual Access Activity", a.Explanation, data); err != nil {
+			log.Printf("[ANOMALY] Push notification failed: %v", err)
+		}
+	}()
+}
+
+// AnomalyStatuses are the review states of an anomaly.
+var AnomalyStatuses = []string{"open", "acknowledged", "dismissed"}
+
+var (
+	ErrAnomalyNotFound      = errors.New("anomaly not found")
+	ErrInvalidAnomalyStatus = errors.New("invalid status, use open, acknowledged or dismissed")
+)
+
+// ReviewAnomaly sets the review status of an anomaly. Reopening it clears the
+// reviewer.
+func ReviewAnomaly(id uint, status, by string) (models.Anomaly, error) {
+	var anomaly models.Anomaly
+	if !slices.Contains(AnomalyStatuses, status) {
+		return anomaly, ErrInvalidAnomalyStatus
+	}
+	if err := config.DB.First(&anomaly, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return anomaly, ErrAnomalyNotFound
+		}
+		return anomaly, err
+	}
+
+	updates := map[string]interface{}{"status": status, "reviewed_by": by, "reviewed_at": time.Now().UTC()}
+	if status == "open" {
+		updates = map[string]interface{}{"status": status, "reviewed_by": "", "reviewed_at": nil}
+	}
+	if err := config.DB.Model(&anomaly).Updates(updates).Error; err != nil {
+		return anomaly, err
+	}
+	if err := config.DB.First(&anomaly, anomaly.ID).Error; err != nil {
+		return anomaly, err
+	}
+	anomaly.OccurredAt = anomaly.OccurredAt.In(config.SiteLocation)
+	return anomaly, nil
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AttendanceConfig is the office schedule and how cameras are used for
+// attendance.
+type AttendanceConfig struct {
+	EntryCameras map[string]bool
+	ExitCameras  map[string]bool
+	StartTime    time.Duration // after local midnight
+	Grace        time.Duration
+	Workdays     map[time.Weekday]bool
+}
+
+var (
+	attendanceOnce   sync.Once
+	attendanceConfig AttendanceConfig
+)
+
+// Attendance returns the configuration read from the ATTENDANCE_* variables
+// on first use.
+func Attendance() AttendanceConfig {
+	attendanceOnce.Do(func() {
+		attendanceConfig = LoadAttendanceConfig()
+	})
+	return attendanceConfig
+}
+
+// LoadAttendanceConfig reads ATTENDANCE_ENTRY_CAMERAS, ATTENDANCE_EXIT_CAMERAS,
+// ATTENDANCE_START, ATTENDANCE_GRACE and ATTENDANCE_WORKDAYS.
+func LoadAttendanceConfig() AttendanceConfig {
+	cfg := AttendanceConfig{
+		EntryCameras: csvSet(os.Getenv("ATTENDANCE_ENTRY_CAMERAS")),
+		ExitCameras:  csvSet(os.Getenv("ATTENDANCE_EXIT_CAMERAS")),
+		StartTime:    9 * time.Hour,
+		Grace:        envDuration("ATTENDANCE_GRACE", 15*time.Minute),
+		Workdays:     map[time.Weekday]bool{},
+	}
+
+	if v := os.Getenv("ATTENDANCE_START"); v != "" {
+		if t, err := time.Parse("15:04", v); err == nil {
+			cfg.StartTime = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+		} else {
+			log.Printf("Warning: invalid ATTENDANCE_START %q, using 09:00", v)
+		}
+	}
+
+	workdays := os.Getenv("ATTENDANCE_WORKDAYS")
+	if workdays == "" {
+		workdays = "1,2,3,4,5"
+	}
+	for _, d := range strings.Split(workdays, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(d))
+		if err != nil || n < 0 || n > 6 {
+			log.Printf("Warning: invalid ATTENDANCE_WORKDAYS entry %q, use 0 (Sunday) to 6 (Saturday)", d)
+			continue
+		}
+		cfg.Workdays[time.Weekday(n)] = true
+	}
+	return cfg
+}
+
+func csvSet(list string) map[string]bool {
+	set := make(map[string]bool)
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			set[v] = true
+		}
+	}
+	return set
+}
+
+// AttendanceEvent is one authorized detection used for attendance.
+type AttendanceEvent struct {
+	Name       string
+	CameraID   string
+	OccurredAt time.Time
+}
+
+// AttendanceDay is the attendance of one person on one local day.
+type AttendanceDay struct {
+	Date            string     `json:"date"`
+	Name            string     `json:"name"`
+	Team            string     `json:"team"`
+	Workday         bool       `json:"workday"`
+	FirstIn         *time.Time `json:"first_in"`
+	LastOut         *time.Time `json:"last_out"`
+	PresenceSeconds int64      `json:"presence_seconds"`
+	Detections      int        `json:"detections"`
+	Late            bool       `json:"late"`
+	LateBySeconds   int64      `json:"late_by_seconds,omitempty"`
+	Absent          bool       `json:"absent"`
+}
+
+// ComputeAttendance builds one row per person and day. days holds the local
+// midnight of every reported day. People are the given members (name to team)
+// plus anyone detected; members with no detection on a workday are absent
+// once its grace period has passed. events must be sorted by time.
+func ComputeAttendance(cfg AttendanceConfig, events []AttendanceEvent, teams map[string]string, days []time.Time, now time.Time, loc *time.Location) []AttendanceDay {
+	type dayKey struct{ date, name string }
+	byDay := make(map[dayKey][]AttendanceEvent)
+	people := make(map[string]bool)
+	for name := range teams {
+		people[name] = true
+	}
+	for _, e := range events {
+		key := dayKey{e.OccurredAt.In(loc).Format("2006-01-02"), e.Name}
+		byDay[key] = append(byDay[key], e)
+		people[e.Name] = true
+	}
+
+	names := make([]string, 0, len(people))
+	for name := range people {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var rows []AttendanceDay
+	for _, day := range days {
+		local := day.In(loc)
+		date := local.Format("2006-01-02")
+		workday := cfg.Workdays[local.Weekday()]
+		// wall clock start, so DST days do not shift it
+		y, m, d := local.Date()
+		scheduled := time.Date(y, m, d, int(cfg.StartTime/time.Hour), int(cfg.StartTime%time.Hour/time.Minute), 0, 0, loc)
+		due := scheduled.Add(cfg.Grace)
+
+		for _, name := range names {
+			_, member := teams[name]
+			dayEvents := byDay[dayKey{date, name}]
+			if len(dayEvents) == 0 {
+				// only members are expected, and only once the workday's grace period is over
+				if member && workday && now.After(due) {
+					rows = append(rows, AttendanceDay{Date: date, Name: name, Team: teams[name], Workday: true, Absent: true})
+				}
+				continue
+			}
+
+			row := summarizeAttendanceDay(cfg, dayEvents)
+			row.Date, row.Name, row.Team, row.Workday = date, name, teams[name], workday
+			if workday && row.FirstIn.After(due) {
+				row.Late = true
+				row.LateBySeconds = int64(row.FirstIn.Sub(scheduled).Seconds())
+			}
+			firstIn, lastOut := row.FirstIn.In(loc), row.LastOut.In(loc)
+			row.FirstIn, row.LastOut = &firstIn, &lastOut
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// summarizeAttendanceDay finds first-in, last-out and time present from the
+// detections of one person on one day. Without entry and exit cameras the
+// whole span between the first and last detection counts as present.
+// Otherwise presence runs from an entry (or any non-exit camera) to the next
+// exit; a visit still open at the last detection ends there.
+func summarizeAttendanceDay(cfg AttendanceConfig, events []AttendanceEvent) AttendanceDay {
+	row := AttendanceDay{Detections: len(events)}
+	first, last := events[0].OccurredAt, events[len(events)-1].OccurredAt
+	row.FirstIn, row.LastOut = &first, &last
+
+	if len(cfg.EntryCameras) == 0 && len(cfg.ExitCameras) == 0 {
+		row.PresenceSeconds = int64(last.Sub(first).Seconds())
+		return row
+	}
+
+	for _, e := range events {
+		if !cfg.ExitCameras[e.CameraID] {
+			t := e.OccurredAt
+			row.FirstIn = &t
+			break
+		}
+	}
+	for i := len(events) - 1; i >= 0; i-- {
+		if !cfg.EntryCameras[events[i].CameraID] {
+			t := events[i].OccurredAt
+			row.LastOut = &t
+			break
+		}
+	}
+
+	var inside bool
+	var since time.Time
+	var presence time.Duration
+	for _, e := range events {
+		switch {
+		case cfg.ExitCameras[e.CameraID]:
+			if inside {
+				presence += e.OccurredAt.Sub(since)
+				inside = false
+			}
+		case !inside:
+			inside, since = true, e.OccurredAt
+		}
+	}
+	if inside {
+		presence += last.Sub(since)
+	}
+	row.PresenceSeconds = int64(presence.Seconds())
+	return row
+}
+
+// FormatSeconds renders a duration in seconds as H:MM:SS for reports.
+func FormatSeconds(seconds int64) string {
+	return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+}
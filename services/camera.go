@@ -0,0 +1,74 @@
+package services
+
+import (
+	"bytes"
+	"comproBackend/facesvc"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ErrDoorUnlockNotConfigured is returned by UnlockDoor when DOOR_UNLOCK_URL
+// is not set.
+var ErrDoorUnlockNotConfigured = errors.New("door unlock is not configured")
+
+// ErrDoorBadResponse is returned by UnlockDoor when the door controller
+// answers with something other than a JSON object.
+var ErrDoorBadResponse = errors.New("invalid response from door controller")
+
+const maxDoorResponseSize = 64 << 10
+
+var doorClient = &http.Client{Timeout: 10 * time.Second}
+
+// TakeSnapshot returns a current JPEG frame, from the stream relay when
+// someone is watching and from the camera service otherwise.
+func TakeSnapshot() ([]byte, error) {
+	if frame := latestFrame("", snapshotMaxAge); frame != nil {
+		return frame, nil
+	}
+	return facesvc.Default().Snapshot(context.Background())
+}
+
+// UnlockDoor posts an unlock request for door to DOOR_UNLOCK_URL, the door
+// controller. by is recorded as the requester.
+func UnlockDoor(door, by string) (int, map[string]interface{}, error) {
+	url := os.Getenv("DOOR_UNLOCK_URL")
+	if url == "" {
+		return 0, nil, ErrDoorUnlockNotConfigured
+	}
+	body, err := json.Marshal(map[string]string{"door": door, "requested_by": by})
+	if err != nil {
+		return 0, nil, err
+	}
+	resp, err := doorClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDoorResponseSize+1))
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(data) > maxDoorResponseSize {
+		return 0, nil, fmt.Errorf("%w: body larger than %d bytes", ErrDoorBadResponse, maxDoorResponseSize)
+	}
+	var result map[string]interface{}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, &result); err != nil {
+			return 0, nil, fmt.Errorf("%w: %v", ErrDoorBadResponse, err)
+		}
+	}
+	if resp.StatusCode < 300 {
+		log.Printf("[DOOR] %s unlocked door %q", by, door)
+	} else {
+		log.Printf("[DOOR] Unlock of door %q by %s failed: %s", door, by, resp.Status)
+	}
+	return resp.StatusCode, result, nil
+}
@@ -0,0 +1,217 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"comproBackend/facesvc"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+const cameraEventsBackoff = 30 * time.Second
+
+// cameraEventHub is the single connection to the camera service event
+// stream. Every SSE event read from it is handed on unchanged to the
+// /api/camera/events subscribers.
+type cameraEventHub struct {
+	mu   sync.Mutex
+	subs map[chan []byte]struct{}
+
+	idle time.Duration // reconnect when nothing arrives for this long, 0 = never
+}
+
+var cameraEvents = &cameraEventHub{subs: make(map[chan []byte]struct{})}
+
+// StartCameraEventConsumer reads the camera service event stream for the
+// lifetime of the process, reconnecting with backoff. Each event is passed
+// to broadcast and to SubscribeCameraEvents subscribers, and detections are
+// stored as logs, whether or not anyone is watching. A connection on which
+// nothing, not even a keepalive comment, arrives for
+// CAMERA_EVENTS_IDLE_TIMEOUT is considered dead and replaced.
+func StartCameraEventConsumer(broadcast func(raw []byte)) {
+	cameraEvents.idle = envDuration("CAMERA_EVENTS_IDLE_TIMEOUT", 90*time.Second)
+	go cameraEvents.run(broadcast)
+}
+
+// SubscribeCameraEvents returns a channel of raw SSE events from the camera
+// service and a function that ends the subscription. A subscriber that falls
+// more than buffer events behind is dropped and its channel closed.
+func SubscribeCameraEvents(buffer int) (<-chan []byte, func()) {
+	ch := make(chan []byte, buffer)
+	cameraEvents.mu.Lock()
+	cameraEvents.subs[ch] = struct{}{}
+	cameraEvents.mu.Unlock()
+
+	return ch, func() {
+		cameraEvents.mu.Lock()
+		defer cameraEvents.mu.Unlock()
+		if _, ok := cameraEvents.subs[ch]; ok {
+			delete(cameraEvents.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (h *cameraEventHub) publish(block []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- block:
+		default:
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (h *cameraEventHub) run(broadcast func([]byte)) {
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := h.consume(broadcast)
+		if time.Since(started) > time.Minute {
+			backoff = time.Second
+		}
+		log.Printf("[CAMERA] Event stream ended: %v, reconnecting in %s", err, backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > cameraEventsBackoff {
+			backoff = cameraEventsBackoff
+		}
+	}
+}
+
+// consume reads one connection until it fails or stays silent for h.idle.
+// A panic while handling an event ends the connection instead of the
+// consumer.
+func (h *cameraEventHub) consume(broadcast func([]byte)) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	// the watchdog cancels the request, which unblocks a read on a hung
+	// or half-open connection
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var watchdog *time.Timer
+	if h.idle > 0 {
+		watchdog = time.AfterFunc(h.idle, cancel)
+		defer watchdog.Stop()
+	}
+
+	stream, err := facesvc.Default().Events(ctx)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	log.Println("[CAMERA] Connected to event stream")
+
+	reader := bufio.NewReader(stream)
+	var block bytes.Buffer
+	var data [][]byte
+	lines := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("no data for %s", h.idle)
+			}
+			if errors.Is(err, io.EOF) {
+				return errors.New("stream closed")
+			}
+			return err
+		}
+		if watchdog != nil {
+			watchdog.Reset(h.idle)
+		}
+		block.Write(line)
+
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) > 0 {
+			lines++
+			if value, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+				data = append(data, bytes.TrimPrefix(value, []byte(" ")))
+			}
+			continue
+		}
+
+		// a blank line ends the event
+		if lines > 0 {
+			h.publish(bytes.Clone(block.Bytes()))
+			if len(data) > 0 {
+				handleCameraEvent(bytes.Join(data, []byte("\n")), broadcast)
+			}
+		}
+		block.Reset()
+		data, lines = nil, 0
+	}
+}
+
+func handleCameraEvent(raw []byte, broadcast func([]byte)) {
+	var event map[string]interface{}
+	if err := json.Unmarshal(raw, &event); err != nil {
+		return
+	}
+	broadcast(raw)
+	if eventType, ok := event["type"].(string); ok && eventType == "detection" {
+		go saveDetectionLog(event)
+	}
+}
+
+func saveDetectionLog(event map[string]interface{}) {
+	data, ok := event["data"].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	// unknown faces are stored too: they are what the anomaly detector's
+	// unknown burst check counts
+	name, _ := data["name"].(string)
+	if name == "" {
+		return
+	}
+
+	authorized, _ := data["authorized"].(bool)
+	confidence, _ := data["confidence"].(float64)
+	role, _ := data["role"].(string)
+	timestamp, _ := data["timestamp"].(string)
+	cameraID, _ := data["camera_id"].(string)
+	door, _ := data["door"].(string)
+	eventID, _ := data["event_id"].(string)
+
+	if timestamp == "" {
+		timestamp = time.Now().Format(time.RFC3339)
+	}
+	if role == "" {
+		role = "Guest"
+		if name == "Unknown" {
+			role = "Unknown"
+		}
+	}
+
+	logEntry, created, err := IngestLog(LogInput{
+		EventID:    eventID,
+		Authorized: &authorized,
+		Confidence: &confidence,
+		Name:       name,
+		Role:       role,
+		CameraID:   cameraID,
+		Door:       door,
+		Timestamp:  timestamp,
+		detection:  true,
+	})
+	if err != nil {
+		log.Printf("[CAMERA] Failed to save detection of %q (event %q): %v", name, eventID, err)
+	} else if created {
+		log.Printf("Detection logged: %s (authorized: %v, confidence: %.2f)", name, authorized, confidence)
+		CaptureEvidence(logEntry)
+	}
+}
@@ -0,0 +1,375 @@
+package services
+
+import (
+	"bytes"
+	"comproBackend/config"
+	"comproBackend/facesvc"
+	"comproBackend/models"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+const (
+	clipBoundary      = "frame"
+	ClipContentType   = "multipart/x-mixed-replace; boundary=" + clipBoundary
+	maxBufferedFrames = 600
+	maxFrameSize      = 8 << 20
+	snapshotMaxAge    = 2 * time.Second
+)
+
+// EvidenceConfig controls what is captured when a log is created.
+type EvidenceConfig struct {
+	Store          string        `json:"store"`
+	Dir            string        `json:"dir"`
+	ClipBefore     time.Duration `json:"clip_before"`
+	ClipAfter      time.Duration `json:"clip_after"`
+	ThumbnailWidth int           `json:"thumbnail_width"`
+}
+
+// Evidence is the blob store for snapshots and clips, nil when capture is
+// disabled.
+var Evidence BlobStore
+
+var evidenceConfig EvidenceConfig
+
+var frames = &frameBuffer{}
+
+// LoadEvidenceConfig reads the EVIDENCE_* environment variables.
+func LoadEvidenceConfig() EvidenceConfig {
+	cfg := EvidenceConfig{
+		Store:          os.Getenv("EVIDENCE_STORE"),
+		Dir:            os.Getenv("EVIDENCE_DIR"),
+		ClipBefore:     envDuration("EVIDENCE_CLIP_BEFORE", 0),
+		ClipAfter:      envDuration("EVIDENCE_CLIP_AFTER", 0),
+		ThumbnailWidth: envInt("EVIDENCE_THUMBNAIL_WIDTH", 320),
+	}
+	if cfg.Store == "" {
+		cfg.Store = "local"
+	}
+	if cfg.Dir == "" {
+		cfg.Dir = "data/evidence"
+	}
+	if cfg.ThumbnailWidth < 16 {
+		cfg.ThumbnailWidth = 320
+	}
+	return cfg
+}
+
+// StartEvidenceCapture opens the blob store and, when clips are enabled,
+// keeps a rolling buffer of the camera MJPEG stream. Clips are off by default
+// because the buffer watches the default camera all the time, so its stream
+// relay never closes and the camera service keeps encoding at full rate.
+func StartEvidenceCapture() {
+	cfg := LoadEvidenceConfig()
+	if cfg.Store == "none" {
+		log.Println("[EVIDENCE] Evidence capture disabled")
+		return
+	}
+
+	store, err := NewBlobStore(cfg.Store, cfg.Dir)
+	if err != nil {
+		log.Printf("Warning: evidence capture disabled: %v", err)
+		return
+	}
+	Evidence = store
+	evidenceConfig = cfg
+
+	if cfg.ClipBefore+cfg.ClipAfter > 0 {
+		frames.keep = cfg.ClipBefore + cfg.ClipAfter + snapshotMaxAge
+		go frames.run()
+		log.Printf("[EVIDENCE] Storing snapshots and %s/%s clips in %s store", cfg.ClipBefore, cfg.ClipAfter, cfg.Store)
+	} else {
+		log.Printf("[EVIDENCE] Storing snapshots in %s store", cfg.Store)
+	}
+}
+
+type bufferedFrame struct {
+	at   time.Time
+	data []byte
+}
+
+// frameBuffer holds the last few seconds of camera frames.
+type frameBuffer struct {
+	mu     sync.Mutex
+	frames []bufferedFrame
+	keep   time.Duration
+	viewer *StreamViewer
+}
+
+func (b *frameBuffer) add(data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.frames = append(b.frames, bufferedFrame{at: now, data: data})
+	drop := 0
+	for drop < len(b.frames) && (now.Sub(b.frames[drop].at) > b.keep || len(b.frames)-drop > maxBufferedFrames) {
+		drop++
+	}
+	if drop > 0 {
+		b.frames = append(b.frames[:0], b.frames[drop:]...)
+	}
+}
+
+func (b *frameBuffer) isConnected() bool {
+	b.mu.Lock()
+	viewer := b.viewer
+	b.mu.Unlock()
+	return viewer != nil && viewer.Connected()
+}
+
+// latest returns the newest frame if it is not older than maxAge.
+func (b *frameBuffer) latest(maxAge time.Duration) []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n := len(b.frames); n > 0 && time.Since(b.frames[n-1].at) <= maxAge {
+		return b.frames[n-1].data
+	}
+	return nil
+}
+
+func (b *frameBuffer) between(from, to time.Time) []bufferedFrame {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []bufferedFrame
+	for _, f := range b.frames {
+		if !f.at.Before(from) && !f.at.After(to) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// run watches the default camera for the lifetime of the process. It never
+// closes its viewer, so the relay of the default camera stays connected even
+// without other viewers; that is the cost of having frames from before a
+// detection.
+func (b *frameBuffer) run() {
+	viewer := WatchCamera("", StreamOptions{})
+	b.mu.Lock()
+	b.viewer = viewer
+	b.mu.Unlock()
+	for {
+		if frame := viewer.Next(context.Background(), 0); frame != nil {
+			b.add(frame)
+		}
+	}
+}
+
+// CaptureEvidence stores a snapshot, its thumbnail and, when the frame buffer
+// is running, a clip around the detection for the given log. It blocks for
+// the clip's after period, so run it in a goroutine.
+func CaptureEvidence(l models.Log) {
+	if Evidence == nil {
+		return
+	}
+	capturedAt := time.Now()
+
+	snapshot := frames.latest(snapshotMaxAge)
+	if snapshot == nil {
+		var err error
+		if snapshot, err = facesvc.Default().Snapshot(context.Background()); err != nil {
+			log.Printf("[EVIDENCE] No snapshot for log %d: %v", l.ID, err)
+		}
+	}
+	if snapshot != nil {
+		storeEvidence(l.ID, "snapshot", "image/jpeg", snapshot, 0, capturedAt)
+		if thumb, err := makeThumbnail(snapshot, evidenceConfig.ThumbnailWidth); err != nil {
+			log.Printf("[EVIDENCE] Thumbnail for log %d failed: %v", l.ID, err)
+		} else {
+			storeEvidence(l.ID, "thumbnail", "image/jpeg", thumb, 0, capturedAt)
+		}
+	}
+
+	if evidenceConfig.ClipBefore+evidenceConfig.ClipAfter == 0 || !frames.isConnected() {
+		return
+	}
+	time.Sleep(evidenceConfig.ClipAfter)
+	clip := frames.between(capturedAt.Add(-evidenceConfig.ClipBefore), capturedAt.Add(evidenceConfig.ClipAfter))
+	if len(clip) == 0 {
+		return
+	}
+	data, err := encodeClip(clip)
+	if err != nil {
+		log.Printf("[EVIDENCE] Clip for log %d failed: %v", l.ID, err)
+		return
+	}
+	storeEvidence(l.ID, "clip", ClipContentType, data, len(clip), capturedAt)
+}
+
+func evidenceKey(logID uint, kind string) string {
+	ext := ".jpg"
+	if kind == "clip" {
+		ext = ".mjpeg"
+	}
+	return fmt.Sprintf("logs/%d/%s%s", logID, kind, ext)
+}
+
+func storeEvidence(logID uint, kind, contentType string, data []byte, frameCount int, capturedAt time.Time) {
+	key := evidenceKey(logID, kind)
+	size, err := Evidence.Put(key, bytes.NewReader(data))
+	if err != nil {
+		log.Printf("[EVIDENCE] Failed to store %s for log %d: %v", kind, logID, err)
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	evidence := models.LogEvidence{
+		LogID:       logID,
+		Kind:        kind,
+		BlobKey:     key,
+		ContentType: contentType,
+		Size:        size,
+		SHA256:      hex.EncodeToString(sum[:]),
+		Frames:      frameCount,
+		CapturedAt:  capturedAt.UTC(),
+	}
+	if err := config.DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&evidence).Error; err != nil {
+		log.Printf("[EVIDENCE] Failed to record %s for log %d: %v", kind, logID, err)
+		Evidence.Delete(key)
+	}
+}
+
+// DeleteLogEvidence removes the evidence files and rows of permanently
+// deleted logs.
+func DeleteLogEvidence(logIDs []uint) {
+	if len(logIDs) == 0 {
+		return
+	}
+
+	var evidence []models.LogEvidence
+	if err := config.DB.Where("log_id IN ?", logIDs).Find(&evidence).Error; err != nil {
+		log.Printf("[EVIDENCE] Failed to load evidence of deleted logs: %v", err)
+		return
+	}
+	if Evidence != nil {
+		for _, e := range evidence {
+			if err := Evidence.Delete(e.BlobKey); err != nil {
+				log.Printf("[EVIDENCE] Failed to delete %s: %v", e.BlobKey, err)
+			}
+		}
+	}
+	if err := config.DB.Where("log_id IN ?", logIDs).Delete(&models.LogEvidence{}).Error; err != nil {
+		log.Printf("[EVIDENCE] Failed to delete evidence rows: %v", err)
+	}
+}
+
+// makeThumbnail scales a JPEG down to width by averaging pixel blocks.
+func makeThumbnail(data []byte, width int) ([]byte, error) {
+	src, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	bounds := src.Bounds()
+	if bounds.Dx() <= width {
+		return data, nil
+	}
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		sy0 := bounds.Min.Y + y*bounds.Dy()/height
+		sy1 := max(bounds.Min.Y+(y+1)*bounds.Dy()/height, sy0+1)
+		for x := 0; x < width; x++ {
+			sx0 := bounds.Min.X + x*bounds.Dx()/width
+			sx1 := max(bounds.Min.X+(x+1)*bounds.Dx()/width, sx0+1)
+
+			var r, g, b, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					pr, pg, pb, _ := src.At(sx, sy).RGBA()
+					r, g, b, n = r+uint64(pr), g+uint64(pg), b+uint64(pb), n+1
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(b / n >> 8), 0xff})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 75}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeClip writes frames as an MJPEG stream. Each part carries its capture
+// time in X-Timestamp (Unix milliseconds) so ReplayClip can keep the pace.
+func encodeClip(clip []bufferedFrame) ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if err := mw.SetBoundary(clipBoundary); err != nil {
+		return nil, err
+	}
+	for _, f := range clip {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "image/jpeg")
+		header.Set("Content-Length", strconv.Itoa(len(f.data)))
+		header.Set("X-Timestamp", strconv.FormatInt(f.at.UnixMilli(), 10))
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := part.Write(f.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ReplayClip streams a stored clip at its original frame rate. flush is
+// called after every frame.
+func ReplayClip(w io.Writer, flush func(), clip io.Reader) error {
+	reader := multipart.NewReader(clip, clipBoundary)
+	writer := multipart.NewWriter(w)
+	if err := writer.SetBoundary(clipBoundary); err != nil {
+		return err
+	}
+
+	var last int64
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return writer.Close()
+		}
+		if err != nil {
+			return err
+		}
+
+		at, _ := strconv.ParseInt(part.Header.Get("X-Timestamp"), 10, 64)
+		if last > 0 && at > last {
+			time.Sleep(time.Duration(at-last) * time.Millisecond)
+		}
+		last = at
+
+		out, err := writer.CreatePart(part.Header)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, part); err != nil {
+			return err
+		}
+		flush()
+	}
+}
@@ -0,0 +1,431 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// TableWriter streams a table row by row so exports never hold the whole
+// result set in memory.
+type TableWriter interface {
+	WriteRow(values []interface{}) error
+	Close() error
+}
+
+// ExportColumn describes a column of an exported table. Width is only used by
+// the PDF writer and is given in points.
+type ExportColumn struct {
+	Title string
+	Width float64
+}
+
+func formatCell(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(val)
+	case time.Time:
+		return val.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// CSV
+
+type csvTableWriter struct {
+	w *csv.Writer
+}
+
+func NewCSVTableWriter(w io.Writer, columns []ExportColumn) (TableWriter, error) {
+	cw := csv.NewWriter(w)
+	header := make([]string, len(columns))
+	for i, col := range columns {
+		header[i] = col.Title
+	}
+	if err := cw.Write(header); err != nil {
+		return nil, err
+	}
+	return &csvTableWriter{w: cw}, nil
+}
+
+func (t *csvTableWriter) WriteRow(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		record[i] = formatCell(v)
+		if _, ok := v.(string); ok {
+			record[i] = escapeCSVFormula(record[i])
+		}
+	}
+	if err := t.w.Write(record); err != nil {
+		return err
+	}
+	// flush per row so the client receives data while the export runs
+	t.w.Flush()
+	return t.w.Error()
+}
+
+func (t *csvTableWriter) Close() error {
+	t.w.Flush()
+	return t.w.Error()
+}
+
+// escapeCSVFormula prefixes text that a spreadsheet would run as a formula
+// with a quote, so a name like "=HYPERLINK(...)" is shown as typed.
+func escapeCSVFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// XLSX
+
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`
+	xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets></workbook>`
+	xlsxSheetStart = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	xlsxSheetEnd = `</sheetData></worksheet>`
+)
+
+// xlsxTableWriter writes a single-sheet workbook. Cells use inline strings so
+// no shared string table has to be kept in memory, and the sheet part is
+// streamed straight into the zip archive.
+type xlsxTableWriter struct {
+	zw    *zip.Writer
+	sheet io.Writer
+	row   int
+}
+
+func NewXLSXTableWriter(w io.Writer, sheetName string, columns []ExportColumn) (TableWriter, error) {
+	zw := zip.NewWriter(w)
+
+	var escapedName bytes.Buffer
+	_ = xml.EscapeText(&escapedName, []byte(sheetName))
+
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", fmt.Sprintf(xlsxWorkbook, escapedName.String())},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	}
+	for _, part := range parts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.body); err != nil {
+			return nil, err
+		}
+	}
+
+	sheet, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(sheet, xlsxSheetStart); err != nil {
+		return nil, err
+	}
+
+	t := &xlsxTableWriter{zw: zw, sheet: sheet}
+	header := make([]interface{}, len(columns))
+	for i, col := range columns {
+		header[i] = col.Title
+	}
+	if err := t.WriteRow(header); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *xlsxTableWriter) WriteRow(values []interface{}) error {
+	t.row++
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<row r="%d">`, t.row)
+	for _, v := range values {
+		switch val := v.(type) {
+		case float64, int, int64, uint:
+			fmt.Fprintf(&b, `<c><v>%s</v></c>`, formatCell(val))
+		case bool:
+			if val {
+				b.WriteString(`<c t="b"><v>1</v></c>`)
+			} else {
+				b.WriteString(`<c t="b"><v>0</v></c>`)
+			}
+		default:
+			b.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+			if err := xml.EscapeText(&b, []byte(formatCell(val))); err != nil {
+				return err
+			}
+			b.WriteString(`</t></is></c>`)
+		}
+	}
+	b.WriteString(`</row>`)
+	_, err := t.sheet.Write(b.Bytes())
+	return err
+}
+
+func (t *xlsxTableWriter) Close() error {
+	if _, err := io.WriteString(t.sheet, xlsxSheetEnd); err != nil {
+		return err
+	}
+	return t.zw.Close()
+}
+
+// PDF
+
+// PDFReport holds the title block printed on the first page of a PDF export.
+type PDFReport struct {
+	Title   string
+	Lines   []string
+	Summary []string
+}
+
+const (
+	pdfPageWidth    = 842.0 // A4 landscape
+	pdfPageHeight   = 595.0
+	pdfMargin       = 36.0
+	pdfFontSize     = 9.0
+	pdfRowHeight    = 14.0
+	pdfTitleSize    = 16.0
+	pdfCatalogObj   = 1
+	pdfPagesObj     = 2
+	pdfFontObj      = 3
+	pdfBoldFontObj  = 4
+	pdfFirstFreeObj = 5
+)
+
+// pdfTableWriter writes a paginated PDF using the built-in Helvetica fonts.
+// Each page is flushed as soon as it is full, so memory use is bounded by one
+// page regardless of the number of rows.
+type pdfTableWriter struct {
+	w       io.Writer
+	offset  int
+	offsets map[int]int
+	nextObj int
+	pages   []int
+
+	columns []ExportColumn
+	report  PDFReport
+	page    bytes.Buffer
+	y       float64
+	pageNo  int
+}
+
+func NewPDFTableWriter(w io.Writer, report PDFReport, columns []ExportColumn) (TableWriter, error) {
+	t := &pdfTableWriter{
+		w:       w,
+		offsets: make(map[int]int),
+		nextObj: pdfFirstFreeObj,
+		columns: columns,
+		report:  report,
+	}
+
+	if err := t.write("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n"); err != nil {
+		return nil, err
+	}
+	if err := t.writeObj(pdfCatalogObj, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pdfPagesObj)); err != nil {
+		return nil, err
+	}
+	if err := t.writeObj(pdfFontObj, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>"); err != nil {
+		return nil, err
+	}
+	if err := t.writeObj(pdfBoldFontObj, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>"); err != nil {
+		return nil, err
+	}
+
+	t.startPage()
+	return t, nil
+}
+
+func (t *pdfTableWriter) write(s string) error {
+	n, err := io.WriteString(t.w, s)
+	t.offset += n
+	return err
+}
+
+func (t *pdfTableWriter) writeObj(num int, body string) error {
+	t.offsets[num] = t.offset
+	return t.write(fmt.Sprintf("%d 0 obj\n%s\nendobj\n", num, body))
+}
+
+func (t *pdfTableWriter) text(x, y, size float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(&t.page, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, pdfEscape(s))
+}
+
+func (t *pdfTableWriter) startPage() {
+	t.page.Reset()
+	t.pageNo++
+	t.y = pdfPageHeight - pdfMargin
+
+	if t.pageNo == 1 {
+		t.y -= pdfTitleSize
+		t.text(pdfMargin, t.y, pdfTitleSize, true, t.report.Title)
+		t.y -= 6
+		for _, line := range t.report.Lines {
+			t.y -= pdfRowHeight
+			t.text(pdfMargin, t.y, pdfFontSize+1, false, line)
+		}
+		if len(t.report.Summary) > 0 {
+			t.y -= pdfRowHeight * 1.5
+			t.text(pdfMargin, t.y, pdfFontSize+2, true, "Summary")
+			for _, line := range t.report.Summary {
+				t.y -= pdfRowHeight
+				t.text(pdfMargin, t.y, pdfFontSize+1, false, line)
+			}
+		}
+		t.y -= pdfRowHeight
+	}
+
+	// column header
+	t.y -= pdfRowHeight
+	x := pdfMargin
+	for _, col := range t.columns {
+		t.text(x, t.y, pdfFontSize, true, fitText(col.Title, col.Width))
+		x += col.Width
+	}
+	fmt.Fprintf(&t.page, "%.2f %.2f m %.2f %.2f l S\n", pdfMargin, t.y-4, pdfPageWidth-pdfMargin, t.y-4)
+
+	t.text(pdfPageWidth-pdfMargin-40, pdfMargin/2, pdfFontSize, false, fmt.Sprintf("Page %d", t.pageNo))
+}
+
+func (t *pdfTableWriter) WriteRow(values []interface{}) error {
+	if t.y-pdfRowHeight < pdfMargin {
+		if err := t.flushPage(); err != nil {
+			return err
+		}
+		t.startPage()
+	}
+
+	t.y -= pdfRowHeight
+	x := pdfMargin
+	for i, v := range values {
+		if i >= len(t.columns) {
+			break
+		}
+		t.text(x, t.y, pdfFontSize, false, fitText(formatCell(v), t.columns[i].Width))
+		x += t.columns[i].Width
+	}
+	return nil
+}
+
+func (t *pdfTableWriter) flushPage() error {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	if _, err := zw.Write(t.page.Bytes()); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	contentObj := t.nextObj
+	pageObj := t.nextObj + 1
+	t.nextObj += 2
+
+	t.offsets[contentObj] = t.offset
+	if err := t.write(fmt.Sprintf("%d 0 obj\n<< /Length %d /Filter /FlateDecode >>\nstream\n", contentObj, compressed.Len())); err != nil {
+		return err
+	}
+	if err := t.write(compressed.String()); err != nil {
+		return err
+	}
+	if err := t.write("\nendstream\nendobj\n"); err != nil {
+		return err
+	}
+
+	page := fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 %d 0 R /F2 %d 0 R >> >> /Contents %d 0 R >>",
+		pdfPagesObj, pdfPageWidth, pdfPageHeight, pdfFontObj, pdfBoldFontObj, contentObj)
+	if err := t.writeObj(pageObj, page); err != nil {
+		return err
+	}
+	t.pages = append(t.pages, pageObj)
+	return nil
+}
+
+func (t *pdfTableWriter) Close() error {
+	if err := t.flushPage(); err != nil {
+		return err
+	}
+
+	kids := make([]string, len(t.pages))
+	for i, p := range t.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", p)
+	}
+	if err := t.writeObj(pdfPagesObj, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(t.pages))); err != nil {
+		return err
+	}
+
+	xrefOffset := t.offset
+	var xref strings.Builder
+	fmt.Fprintf(&xref, "xref\n0 %d\n0000000000 65535 f \n", t.nextObj)
+	for num := 1; num < t.nextObj; num++ {
+		fmt.Fprintf(&xref, "%010d 00000 n \n", t.offsets[num])
+	}
+	fmt.Fprintf(&xref, "trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", t.nextObj, pdfCatalogObj, xrefOffset)
+	return t.write(xref.String())
+}
+
+// pdfEscape encodes s for a literal string in a WinAnsi font. Characters
+// outside Latin-1 are replaced with '?'.
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(byte(r))
+		case r == '\n' || r == '\r' || r == '\t':
+			b.WriteByte(' ')
+		case r < 0x20:
+			continue
+		case r < 0x80:
+			b.WriteByte(byte(r))
+		case r >= 0xA0 && r <= 0xFF:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// fitText truncates s so it roughly fits width points at the table font size.
+// Helvetica averages about half an em per character.
+func fitText(s string, width float64) string {
+	maxChars := int((width - 4) / (pdfFontSize * 0.5))
+	if maxChars < 1 {
+		return ""
+	}
+	if utf8.RuneCountInString(s) <= maxChars {
+		return s
+	}
+	runes := []rune(s)
+	if maxChars <= 3 {
+		return string(runes[:maxChars])
+	}
+	return string(runes[:maxChars-3]) + "..."
+}
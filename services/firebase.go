@@ -1,6 +1,8 @@
 package services
 
 import (
+	"comproBackend/config"
+	"comproBackend/models"
 	"context"
 	"fmt"
 	"log"
@@ -134,3 +136,13 @@ func SendPushNotificationToMultiple(tokens []string, title, body string, data ma
 	log.Printf("Successfully sent FCM multicast: %d success, %d failures", response.SuccessCount, response.FailureCount)
 	return nil
 }
+
+// SendPushNotificationToAllUsers notifies every user with a registered FCM
+// token.
+func SendPushNotificationToAllUsers(title, body string, data map[string]string) error {
+	var tokens []string
+	if err := config.DB.Model(&models.User{}).Where("fcm_token != '' AND fcm_token IS NOT NULL").Pluck("fcm_token", &tokens).Error; err != nil {
+		return fmt.Errorf("error fetching FCM tokens: %v", err)
+	}
+	return SendPushNotificationToMultiple(tokens, title, body, data)
+}
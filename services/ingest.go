@@ -0,0 +1,215 @@
+package services
+
+import (
+	"comproBackend/config"
+	"comproBackend/models"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const maxEventIDLength = 100
+
+// LogInput is what a client may send when creating a log. Server managed
+// fields (id, chain, deletion) are not accepted.
+type LogInput struct {
+	EventID    string     `json:"event_id"`
+	Authorized *bool      `json:"authorized"`
+	Confidence *float64   `json:"confidence"`
+	Name       string     `json:"name"`
+	Role       string     `json:"role"`
+	CameraID   string     `json:"camera_id"`
+	Door       string     `json:"door"`
+	Timestamp  string     `json:"timestamp"`
+	OccurredAt *time.Time `json:"occurred_at"`
+
+	// detection marks logs from the camera service, whose roles are those of
+	// the enrolled faces and are kept even when not in LOG_ROLES
+	detection bool
+}
+
+// FieldError describes one invalid field of a LogInput.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned by IngestLog when the input is rejected.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		parts[i] = f.Field + ": " + f.Message
+	}
+	return "invalid log: " + strings.Join(parts, "; ")
+}
+
+// ErrIdempotencyConflict means the event ID was already used for a log with
+// different content.
+var ErrIdempotencyConflict = errors.New("event_id was already used for a different log")
+
+var (
+	logRolesOnce sync.Once
+	logRoles     map[string]string
+)
+
+// LogRoles returns the roles a log may carry, keyed by lower case name. They
+// come from LOG_ROLES (comma separated), read on first use.
+func LogRoles() map[string]string {
+	logRolesOnce.Do(func() {
+		list := os.Getenv("LOG_ROLES")
+		if list == "" {
+			list = "user,verifier,verificator,Guest,Unknown"
+		}
+		logRoles = make(map[string]string)
+		for _, role := range strings.Split(list, ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				logRoles[strings.ToLower(role)] = role
+			}
+		}
+	})
+	return logRoles
+}
+
+// Validate checks the input and returns the log to insert. Roles are matched
+// case-insensitively and stored in their configured spelling; only camera
+// detections may carry a role outside LOG_ROLES.
+func (in LogInput) Validate() (models.Log, error) {
+	var errs []FieldError
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	l := models.Log{
+		Name:      strings.TrimSpace(in.Name),
+		CameraID:  strings.TrimSpace(in.CameraID),
+		Door:      strings.TrimSpace(in.Door),
+		Timestamp: strings.TrimSpace(in.Timestamp),
+	}
+
+	if in.EventID = strings.TrimSpace(in.EventID); in.EventID != "" {
+		if len(in.EventID) > maxEventIDLength {
+			add("event_id", "must be at most %d characters", maxEventIDLength)
+		}
+		eventID := in.EventID
+		l.EventID = &eventID
+	}
+
+	if in.Authorized == nil {
+		add("authorized", "is required")
+	} else {
+		l.Authorized = *in.Authorized
+	}
+
+	if in.Confidence == nil {
+		add("confidence", "is required")
+	} else if c := *in.Confidence; math.IsNaN(c) || c < 0 || c > 1 {
+		add("confidence", "must be between 0 and 1")
+	} else {
+		l.Confidence = c
+	}
+
+	if l.Name == "" {
+		add("name", "is required")
+	} else if len(l.Name) > 255 {
+		add("name", "must be at most 255 characters")
+	}
+
+	role := strings.TrimSpace(in.Role)
+	if configured, ok := LogRoles()[strings.ToLower(role)]; ok {
+		l.Role = configured
+	} else if role == "" {
+		add("role", "is required")
+	} else if !in.detection {
+		add("role", "unknown role %q", in.Role)
+	} else if len(role) > 100 {
+		add("role", "must be at most 100 characters")
+	} else {
+		l.Role = role
+	}
+
+	if len(l.CameraID) > 100 {
+		add("camera_id", "must be at most 100 characters")
+	}
+	if len(l.Door) > 100 {
+		add("door", "must be at most 100 characters")
+	}
+
+	if in.OccurredAt != nil {
+		l.OccurredAt = *in.OccurredAt
+	} else if l.Timestamp != "" {
+		t, err := models.ParseTimestamp(l.Timestamp, models.NaiveTimestampLocation)
+		if err != nil {
+			add("timestamp", "%v", err)
+		} else {
+			l.OccurredAt = t
+		}
+	}
+
+	if len(errs) > 0 {
+		return l, &ValidationError{Fields: errs}
+	}
+	return l, nil
+}
+
+// IngestLog validates and stores a log. When the input carries an event ID
+// that was stored before, the existing log is returned with created false, so
+// retries never create duplicates.
+func IngestLog(in LogInput) (models.Log, bool, error) {
+	l, err := in.Validate()
+	if err != nil {
+		return l, false, err
+	}
+
+	if l.EventID != nil {
+		if existing, found, err := findLogByEventID(*l.EventID); err != nil || found {
+			if found && !sameLogContent(existing, l) {
+				return existing, false, ErrIdempotencyConflict
+			}
+			return existing, false, err
+		}
+	}
+
+	if err := config.DB.Create(&l).Error; err != nil {
+		var mysqlErr *mysql.MySQLError
+		if l.EventID != nil && errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+			// a concurrent retry inserted the same event first
+			existing, found, findErr := findLogByEventID(*l.EventID)
+			if findErr == nil && found {
+				if !sameLogContent(existing, l) {
+					return existing, false, ErrIdempotencyConflict
+				}
+				return existing, false, nil
+			}
+		}
+		return l, false, err
+	}
+	PublishLogEvent(LogEvent{Type: LogEventCreated, Log: l})
+	return l, true, nil
+}
+
+func findLogByEventID(eventID string) (models.Log, bool, error) {
+	var logs []models.Log
+	// trashed logs count too, a retry must not bring a deleted event back
+	if err := config.DB.Unscoped().Where("event_id = ?", eventID).Limit(1).Find(&logs).Error; err != nil {
+		return models.Log{}, false, err
+	}
+	if len(logs) == 0 {
+		return models.Log{}, false, nil
+	}
+	return logs[0], true, nil
+}
+
+func sameLogContent(a, b models.Log) bool {
+	return a.Name == b.Name && a.Role == b.Role && a.Authorized == b.Authorized &&
+		a.Confidence == b.Confidence && a.CameraID == b.CameraID && a.Door == b.Door
+}
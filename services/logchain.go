@@ -0,0 +1,395 @@
+package services
+
+import (
+	"comproBackend/config"
+	"comproBackend/models"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const chainVerifyBatchSize = 1000
+
+var signingKey ed25519.PrivateKey
+
+// LoadSigningKey reads the Ed25519 seed used to sign chain checkpoints and
+// tombstones from LOG_SIGNING_KEY_PATH, generating it on first start.
+func LoadSigningKey() error {
+	path := os.Getenv("LOG_SIGNING_KEY_PATH")
+	if path == "" {
+		path = "log-signing.key"
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		seed := make([]byte, ed25519.SeedSize)
+		if _, err := rand.Read(seed); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(seed)+"\n"), 0o600); err != nil {
+			return fmt.Errorf("write signing key: %w", err)
+		}
+		log.Printf("[CHAIN] Generated new log signing key at %s, back it up", path)
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return fmt.Errorf("read signing key: %w", err)
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return fmt.Errorf("signing key at %s must be a base64 %d-byte Ed25519 seed", path, ed25519.SeedSize)
+	}
+
+	signingKey = ed25519.NewKeyFromSeed(seed)
+	log.Printf("[CHAIN] Log signing key %s loaded", SigningKeyID())
+	return nil
+}
+
+// SigningPublicKey returns the base64 public key checkpoints can be verified
+// with outside the backend.
+func SigningPublicKey() string {
+	if signingKey == nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(signingKey.Public().(ed25519.PublicKey))
+}
+
+// SigningKeyID is a short fingerprint of the public key.
+func SigningKeyID() string {
+	if signingKey == nil {
+		return ""
+	}
+	sum := sha256.Sum256(signingKey.Public().(ed25519.PublicKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+func sign(payload interface{}) (string, error) {
+	if signingKey == nil {
+		return "", errors.New("log signing key not loaded")
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(signingKey, data)), nil
+}
+
+func verifySignature(payload interface{}, signature string) bool {
+	if signingKey == nil {
+		return false
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(signingKey.Public().(ed25519.PublicKey), data, sig)
+}
+
+type checkpointPayload struct {
+	Seq       uint64 `json:"seq"`
+	Hash      string `json:"hash"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+type tombstonePayload struct {
+	FromSeq   uint64 `json:"from_seq"`
+	ToSeq     uint64 `json:"to_seq"`
+	PrevHash  string `json:"prev_hash"`
+	LastHash  string `json:"last_hash"`
+	Count     int    `json:"count"`
+	Reason    string `json:"reason"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+func checkpointPayloadOf(cp models.LogCheckpoint) checkpointPayload {
+	return checkpointPayload{Seq: cp.Seq, Hash: cp.Hash, CreatedAt: cp.CreatedAt.UnixMilli()}
+}
+
+func tombstonePayloadOf(t models.LogTombstone) tombstonePayload {
+	return tombstonePayload{
+		FromSeq:   t.FromSeq,
+		ToSeq:     t.ToSeq,
+		PrevHash:  t.PrevHash,
+		LastHash:  t.LastHash,
+		Count:     t.Count,
+		Reason:    t.Reason,
+		CreatedAt: t.CreatedAt.UnixMilli(),
+	}
+}
+
+// RecordTombstones stores signed tombstones for logs about to be removed
+// permanently. Consecutive chain entries are collapsed into one tombstone.
+// Call it in the same transaction as the delete.
+func RecordTombstones(tx *gorm.DB, logs []models.Log, reason string) error {
+	var chained []models.Log
+	for _, l := range logs {
+		if l.ChainSeq != nil {
+			chained = append(chained, l)
+		}
+	}
+	if len(chained) == 0 {
+		return nil
+	}
+	sort.Slice(chained, func(i, j int) bool { return *chained[i].ChainSeq < *chained[j].ChainSeq })
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	var tombstones []models.LogTombstone
+	for _, l := range chained {
+		seq := *l.ChainSeq
+		if n := len(tombstones); n > 0 && tombstones[n-1].ToSeq+1 == seq {
+			tombstones[n-1].ToSeq = seq
+			tombstones[n-1].LastHash = l.Hash
+			tombstones[n-1].Count++
+			continue
+		}
+		tombstones = append(tombstones, models.LogTombstone{
+			FromSeq:   seq,
+			ToSeq:     seq,
+			PrevHash:  l.PrevHash,
+			LastHash:  l.Hash,
+			Count:     1,
+			Reason:    reason,
+			CreatedAt: now,
+		})
+	}
+
+	for i := range tombstones {
+		signature, err := sign(tombstonePayloadOf(tombstones[i]))
+		if err != nil {
+			return err
+		}
+		tombstones[i].KeyID = SigningKeyID()
+		tombstones[i].Signature = signature
+	}
+	return tx.Create(&tombstones).Error
+}
+
+// CreateCheckpoint signs the current chain head unless it was already signed.
+func CreateCheckpoint() (*models.LogCheckpoint, error) {
+	var head models.LogChainHead
+	if err := config.DB.First(&head, 1).Error; err != nil {
+		return nil, err
+	}
+	if head.Seq == 0 {
+		return nil, nil
+	}
+
+	var last models.LogCheckpoint
+	err := config.DB.Order("seq DESC").First(&last).Error
+	if err == nil && last.Seq >= head.Seq {
+		return nil, nil
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	cp := models.LogCheckpoint{
+		Seq:       head.Seq,
+		Hash:      head.Hash,
+		KeyID:     SigningKeyID(),
+		CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+	}
+	if cp.Signature, err = sign(checkpointPayloadOf(cp)); err != nil {
+		return nil, err
+	}
+	if err := config.DB.Create(&cp).Error; err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// StartCheckpointWorker signs the chain head every LOG_CHECKPOINT_INTERVAL
+// (default 1h) when new logs were appended since the last checkpoint.
+func StartCheckpointWorker() {
+	interval := envDuration("LOG_CHECKPOINT_INTERVAL", time.Hour)
+	if interval == 0 {
+		log.Printf("Warning: LOG_CHECKPOINT_INTERVAL must be positive, using %s", time.Hour)
+		interval = time.Hour
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			cp, err := CreateCheckpoint()
+			if err != nil {
+				log.Printf("[CHAIN] Checkpoint failed: %v", err)
+				continue
+			}
+			if cp != nil {
+				log.Printf("[CHAIN] Signed checkpoint at seq %d", cp.Seq)
+			}
+		}
+	}()
+}
+
+// ChainBreak describes the first inconsistency found while verifying.
+type ChainBreak struct {
+	Seq    uint64 `json:"seq"`
+	LogID  uint   `json:"log_id,omitempty"`
+	Reason string `json:"reason"`
+}
+
+type ChainReport struct {
+	OK                   bool        `json:"ok"`
+	HeadSeq              uint64      `json:"head_seq"`
+	CheckedLogs          int64       `json:"checked_logs"`
+	CheckedTombstones    int         `json:"checked_tombstones"`
+	CheckedCheckpoints   int         `json:"checked_checkpoints"`
+	UncheckedCheckpoints int         `json:"unchecked_checkpoints"` // inside a tombstone, nothing left to compare
+	UnchainedLogs        int64       `json:"unchained_logs"`        // rows without chain_seq, written outside the backend
+	FirstBroken          *ChainBreak `json:"first_broken,omitempty"`
+	PublicKey            string      `json:"public_key"`
+	VerifiedAt           time.Time   `json:"verified_at"`
+}
+
+// VerifyLogChain walks the chain from the first entry to the current head and
+// reports the first broken link: a modified row, a missing entry without a
+// tombstone, a bad tombstone or checkpoint signature, or a checkpoint whose
+// hash no longer matches the chain. Logs that are not in the chain at all were
+// inserted behind the backend's back and fail the verification as well.
+func VerifyLogChain() (ChainReport, error) {
+	report := ChainReport{PublicKey: SigningPublicKey(), VerifiedAt: time.Now()}
+
+	var head models.LogChainHead
+	if err := config.DB.First(&head, 1).Error; err != nil {
+		return report, err
+	}
+	report.HeadSeq = head.Seq
+
+	if err := config.DB.Unscoped().Model(&models.Log{}).Where("chain_seq IS NULL").Count(&report.UnchainedLogs).Error; err != nil {
+		return report, err
+	}
+	if report.UnchainedLogs > 0 {
+		var first models.Log
+		if err := config.DB.Unscoped().Select("id").Where("chain_seq IS NULL").Order("id").First(&first).Error; err != nil {
+			return report, err
+		}
+		report.FirstBroken = &ChainBreak{LogID: first.ID, Reason: fmt.Sprintf("%d logs are not in the chain, they were written outside the backend", report.UnchainedLogs)}
+		return report, nil
+	}
+
+	var tombstones []models.LogTombstone
+	if err := config.DB.Where("from_seq <= ?", head.Seq).Order("from_seq").Find(&tombstones).Error; err != nil {
+		return report, err
+	}
+
+	var checkpoints []models.LogCheckpoint
+	if err := config.DB.Where("seq <= ?", head.Seq).Order("seq").Find(&checkpoints).Error; err != nil {
+		return report, err
+	}
+	checkpointsBySeq := make(map[uint64][]models.LogCheckpoint)
+	for _, cp := range checkpoints {
+		if !verifySignature(checkpointPayloadOf(cp), cp.Signature) {
+			report.FirstBroken = &ChainBreak{Seq: cp.Seq, Reason: fmt.Sprintf("checkpoint %d has an invalid signature", cp.ID)}
+			return report, nil
+		}
+		checkpointsBySeq[cp.Seq] = append(checkpointsBySeq[cp.Seq], cp)
+	}
+
+	broken := func(seq uint64, logID uint, reason string) (ChainReport, error) {
+		report.FirstBroken = &ChainBreak{Seq: seq, LogID: logID, Reason: reason}
+		return report, nil
+	}
+	checkCheckpoints := func(seq uint64, hash string) bool {
+		for _, cp := range checkpointsBySeq[seq] {
+			if cp.Hash != hash {
+				return false
+			}
+			report.CheckedCheckpoints++
+		}
+		delete(checkpointsBySeq, seq)
+		return true
+	}
+
+	expectedSeq := uint64(1)
+	expectedPrev := ""
+	nextTomb := 0
+	var lastSeq uint64
+
+	for expectedSeq <= head.Seq {
+		var batch []models.Log
+		if err := config.DB.Unscoped().
+			Where("chain_seq > ? AND chain_seq <= ?", lastSeq, head.Seq).
+			Order("chain_seq").Limit(chainVerifyBatchSize).
+			Find(&batch).Error; err != nil {
+			return report, err
+		}
+
+		for i := 0; i <= len(batch); i++ {
+			// consume tombstones covering the gap before the next row
+			for nextTomb < len(tombstones) && tombstones[nextTomb].FromSeq == expectedSeq {
+				t := tombstones[nextTomb]
+				if !verifySignature(tombstonePayloadOf(t), t.Signature) {
+					return broken(t.FromSeq, 0, fmt.Sprintf("tombstone %d has an invalid signature", t.ID))
+				}
+				if t.PrevHash != expectedPrev {
+					return broken(t.FromSeq, 0, fmt.Sprintf("tombstone %d does not link to the previous entry", t.ID))
+				}
+				if !checkCheckpoints(t.ToSeq, t.LastHash) {
+					return broken(t.ToSeq, 0, "checkpoint hash does not match tombstone")
+				}
+				expectedPrev = t.LastHash
+				expectedSeq = t.ToSeq + 1
+				report.CheckedTombstones++
+				nextTomb++
+			}
+
+			if i == len(batch) {
+				break
+			}
+			l := batch[i]
+			seq := *l.ChainSeq
+			if seq != expectedSeq {
+				return broken(expectedSeq, 0, fmt.Sprintf("entry %d is missing and no tombstone records its removal", expectedSeq))
+			}
+			if l.PrevHash != expectedPrev {
+				return broken(seq, l.ID, "prev_hash does not match the previous entry")
+			}
+			if l.ChainHash(seq, l.PrevHash) != l.Hash {
+				return broken(seq, l.ID, "log content does not match its hash")
+			}
+			if !checkCheckpoints(seq, l.Hash) {
+				return broken(seq, l.ID, "hash does not match a signed checkpoint")
+			}
+			expectedPrev = l.Hash
+			expectedSeq = seq + 1
+			lastSeq = seq
+			report.CheckedLogs++
+		}
+
+		if len(batch) < chainVerifyBatchSize {
+			break
+		}
+	}
+
+	if expectedSeq <= head.Seq {
+		return broken(expectedSeq, 0, fmt.Sprintf("entry %d is missing and no tombstone records its removal", expectedSeq))
+	}
+	if expectedPrev != head.Hash {
+		return broken(head.Seq, 0, "chain head hash does not match the last entry")
+	}
+
+	for _, cps := range checkpointsBySeq {
+		report.UncheckedCheckpoints += len(cps)
+	}
+	report.OK = true
+	return report, nil
+}
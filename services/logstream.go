@@ -0,0 +1,58 @@
+package services
+
+import (
+	"comproBackend/models"
+	"sync"
+)
+
+// Log event types published to stream subscribers.
+const (
+	LogEventCreated  = "log"
+	LogEventReviewed = "log_review"
+)
+
+// LogEvent is a log that was just stored or whose review status changed.
+type LogEvent struct {
+	Type string
+	Log  models.Log
+}
+
+type logEventHub struct {
+	mu   sync.Mutex
+	subs map[chan LogEvent]struct{}
+}
+
+var logEvents = &logEventHub{subs: make(map[chan LogEvent]struct{})}
+
+// SubscribeLogEvents returns a channel of log events and a function that ends
+// the subscription. A subscriber that falls more than buffer events behind is
+// dropped and its channel closed; it can catch up from the database.
+func SubscribeLogEvents(buffer int) (<-chan LogEvent, func()) {
+	ch := make(chan LogEvent, buffer)
+	logEvents.mu.Lock()
+	logEvents.subs[ch] = struct{}{}
+	logEvents.mu.Unlock()
+
+	return ch, func() {
+		logEvents.mu.Lock()
+		defer logEvents.mu.Unlock()
+		if _, ok := logEvents.subs[ch]; ok {
+			delete(logEvents.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// PublishLogEvent hands e to every subscriber without blocking.
+func PublishLogEvent(e LogEvent) {
+	logEvents.mu.Lock()
+	defer logEvents.mu.Unlock()
+	for ch := range logEvents.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(logEvents.subs, ch)
+			close(ch)
+		}
+	}
+}
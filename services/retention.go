@@ -0,0 +1,314 @@
+package services
+
+import (
+	"compress/gzip"
+	"comproBackend/config"
+	"comproBackend/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RetentionPolicy controls how long logs are kept. A zero number of days
+// keeps that category forever.
+type RetentionPolicy struct {
+	AuthorizedDays   int           `json:"authorized_days"`
+	UnauthorizedDays int           `json:"unauthorized_days"`
+	BatchSize        int           `json:"batch_size"`
+	Interval         time.Duration `json:"-"`
+	ArchiveDir       string        `json:"archive_dir"`
+}
+
+// RetentionResult describes one purge run, or what a run would do when it is
+// a dry run.
+type RetentionResult struct {
+	DryRun             bool       `json:"dry_run"`
+	AuthorizedCutoff   *time.Time `json:"authorized_cutoff"`
+	UnauthorizedCutoff *time.Time `json:"unauthorized_cutoff"`
+	Authorized         int64      `json:"authorized"`
+	Unauthorized       int64      `json:"unauthorized"`
+	Oldest             *time.Time `json:"oldest,omitempty"`
+	Newest             *time.Time `json:"newest,omitempty"`
+	ArchiveFile        string     `json:"archive_file,omitempty"`
+}
+
+var ErrRetentionRunning = errors.New("retention purge already running")
+
+// Retention is the active policy, loaded by StartRetentionWorker.
+var Retention RetentionPolicy
+
+var retentionMutex sync.Mutex
+
+// LoadRetentionPolicy reads the LOG_RETENTION_* and LOG_ARCHIVE_DIR
+// environment variables.
+func LoadRetentionPolicy() RetentionPolicy {
+	policy := RetentionPolicy{
+		AuthorizedDays:   envInt("LOG_RETENTION_AUTHORIZED_DAYS", 0),
+		UnauthorizedDays: envInt("LOG_RETENTION_UNAUTHORIZED_DAYS", 0),
+		BatchSize:        envInt("LOG_RETENTION_BATCH_SIZE", 500),
+		Interval:         envDuration("LOG_RETENTION_INTERVAL", time.Hour),
+		ArchiveDir:       os.Getenv("LOG_ARCHIVE_DIR"),
+	}
+	if policy.Interval == 0 {
+		log.Printf("Warning: LOG_RETENTION_INTERVAL must be positive, using %s", time.Hour)
+		policy.Interval = time.Hour
+	}
+	if policy.BatchSize < 1 {
+		policy.BatchSize = 500
+	}
+	return policy
+}
+
+func (p RetentionPolicy) Enabled() bool {
+	return p.AuthorizedDays > 0 || p.UnauthorizedDays > 0
+}
+
+// StartRetentionWorker purges expired logs every policy interval. It returns
+// immediately when no retention period is configured.
+func StartRetentionWorker() {
+	Retention = LoadRetentionPolicy()
+	if !Retention.Enabled() {
+		log.Println("[RETENTION] No retention period configured, logs are kept forever")
+		return
+	}
+
+	log.Printf("[RETENTION] Keeping authorized logs %d days, unauthorized %d days (0 = forever), checking every %s",
+		Retention.AuthorizedDays, Retention.UnauthorizedDays, Retention.Interval)
+
+	go func() {
+		ticker := time.NewTicker(Retention.Interval)
+		defer ticker.Stop()
+		for {
+			if _, err := PurgeExpiredLogs(Retention, time.Now(), false); err != nil && !errors.Is(err, ErrRetentionRunning) {
+				log.Printf("[RETENTION] Purge failed: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// PurgeExpiredLogs deletes logs older than the policy allows in batches of
+// policy.BatchSize. When an archive directory is configured every batch is
+// appended to a gzipped NDJSON file and synced to disk before it is deleted.
+// With dryRun set nothing is changed and the counts of what would be purged
+// are returned.
+func PurgeExpiredLogs(policy RetentionPolicy, now time.Time, dryRun bool) (RetentionResult, error) {
+	result := RetentionResult{DryRun: dryRun}
+	if !policy.Enabled() {
+		return result, nil
+	}
+
+	if !retentionMutex.TryLock() {
+		return result, ErrRetentionRunning
+	}
+	defer retentionMutex.Unlock()
+
+	expired := expiredLogsQuery(policy, now, &result)
+
+	if dryRun {
+		var preview struct {
+			Authorized   int64
+			Unauthorized int64
+			Oldest       *time.Time
+			Newest       *time.Time
+		}
+		err := expired().Select(
+			"COALESCE(SUM(authorized), 0) AS authorized, " +
+				"COALESCE(SUM(NOT authorized), 0) AS unauthorized, " +
+				"MIN(occurred_at) AS oldest, MAX(occurred_at) AS newest",
+		).Scan(&preview).Error
+		result.Authorized, result.Unauthorized = preview.Authorized, preview.Unauthorized
+		result.Oldest, result.Newest = preview.Oldest, preview.Newest
+		return result, err
+	}
+
+	var archive *logArchive
+	if policy.ArchiveDir != "" {
+		var err error
+		archive, err = openLogArchive(policy.ArchiveDir, now)
+		if err != nil {
+			return result, err
+		}
+		result.ArchiveFile = archive.path
+	}
+
+	for {
+		var batch []models.Log
+		if err := expired().Order("id").Limit(policy.BatchSize).Find(&batch).Error; err != nil {
+			archive.close()
+			return result, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		if archive != nil {
+			if err := archive.write(batch); err != nil {
+				archive.close()
+				return result, fmt.Errorf("archive logs: %w", err)
+			}
+		}
+
+		ids := make([]uint, len(batch))
+		for i, l := range batch {
+			ids[i] = l.ID
+			if l.Authorized {
+				result.Authorized++
+			} else {
+				result.Unauthorized++
+			}
+			if result.Oldest == nil || l.OccurredAt.Before(*result.Oldest) {
+				t := l.OccurredAt
+				result.Oldest = &t
+			}
+			if result.Newest == nil || l.OccurredAt.After(*result.Newest) {
+				t := l.OccurredAt
+				result.Newest = &t
+			}
+		}
+
+		if err := config.DB.Transaction(func(tx *gorm.DB) error {
+			if err := RecordTombstones(tx, batch, "retention"); err != nil {
+				return err
+			}
+			return tx.Unscoped().Where("id IN ?", ids).Delete(&models.Log{}).Error
+		}); err != nil {
+			archive.close()
+			return result, err
+		}
+		DeleteLogEvidence(ids)
+		DeleteLogAnnotations(ids)
+
+		if len(batch) < policy.BatchSize {
+			break
+		}
+	}
+
+	if err := archive.close(); err != nil {
+		return result, fmt.Errorf("archive logs: %w", err)
+	}
+	if archive != nil && result.Authorized+result.Unauthorized == 0 {
+		os.Remove(archive.path)
+		result.ArchiveFile = ""
+	}
+
+	if purged := result.Authorized + result.Unauthorized; purged > 0 {
+		log.Printf("[RETENTION] Purged %d logs (%d authorized, %d unauthorized)", purged, result.Authorized, result.Unauthorized)
+	}
+	return result, nil
+}
+
+// expiredLogsQuery returns a constructor for the query matching every log past
+// its retention period and records the cutoffs in result.
+func expiredLogsQuery(policy RetentionPolicy, now time.Time, result *RetentionResult) func() *gorm.DB {
+	var conds []string
+	var args []interface{}
+	if policy.AuthorizedDays > 0 {
+		cutoff := now.AddDate(0, 0, -policy.AuthorizedDays).UTC()
+		result.AuthorizedCutoff = &cutoff
+		conds = append(conds, "(authorized = ? AND occurred_at < ?)")
+		args = append(args, true, cutoff)
+	}
+	if policy.UnauthorizedDays > 0 {
+		cutoff := now.AddDate(0, 0, -policy.UnauthorizedDays).UTC()
+		result.UnauthorizedCutoff = &cutoff
+		conds = append(conds, "(authorized = ? AND occurred_at < ?)")
+		args = append(args, false, cutoff)
+	}
+
+	where := conds[0]
+	if len(conds) > 1 {
+		where = conds[0] + " OR " + conds[1]
+	}
+	return func() *gorm.DB {
+		// trashed logs expire like any other
+		return config.DB.Unscoped().Model(&models.Log{}).Where(where, args...)
+	}
+}
+
+type logArchive struct {
+	path string
+	file *os.File
+	gz   *gzip.Writer
+	enc  *json.Encoder
+}
+
+// openLogArchive creates a new archive file named after now. An existing
+// archive is never overwritten: when a run in the same second already took the
+// name, a counter is appended.
+func openLogArchive(dir string, now time.Time) (*logArchive, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	name := "logs-" + now.UTC().Format("20060102T150405Z")
+	path := filepath.Join(dir, name+".ndjson.gz")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o640)
+	for n := 1; errors.Is(err, os.ErrExist) && n < 1000; n++ {
+		path = filepath.Join(dir, fmt.Sprintf("%s-%d.ndjson.gz", name, n))
+		file, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o640)
+	}
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(file)
+	return &logArchive{path: path, file: file, gz: gz, enc: json.NewEncoder(gz)}, nil
+}
+
+// write appends the batch and makes sure it reached the disk, so rows are
+// only deleted once their archive copy is durable.
+func (a *logArchive) write(batch []models.Log) error {
+	for _, l := range batch {
+		if err := a.enc.Encode(l); err != nil {
+			return err
+		}
+	}
+	if err := a.gz.Flush(); err != nil {
+		return err
+	}
+	return a.file.Sync()
+}
+
+func (a *logArchive) close() error {
+	if a == nil {
+		return nil
+	}
+	if err := a.gz.Close(); err != nil {
+		a.file.Close()
+		return err
+	}
+	return a.file.Close()
+}
+
+func envInt(name string, fallback int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Warning: invalid %s %q, using %d", name, v, fallback)
+		return fallback
+	}
+	return n
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("Warning: invalid %s %q, using %s", name, v, fallback)
+		return fallback
+	}
+	return d
+}
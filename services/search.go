@@ -0,0 +1,369 @@
+package services
+
+import (
+	"comproBackend/config"
+	"comproBackend/facesvc"
+	"comproBackend/models"
+	"context"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Search result types.
+const (
+	SearchTypeLog  = "log"  // a name seen in the access logs
+	SearchTypeUser = "user" // an app account
+	SearchTypeFace = "face" // an identity enrolled in the face service
+)
+
+var SearchTypes = []string{SearchTypeLog, SearchTypeUser, SearchTypeFace}
+
+const searchRefresh = time.Minute
+
+// SearchSpan marks a matched part of a result title, in rune offsets.
+type SearchSpan struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// SearchResult is one typed search hit.
+type SearchResult struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id"`
+	Title      string                 `json:"title"`
+	Score      float64                `json:"score"`
+	Highlights []SearchSpan           `json:"highlights"`
+	Data       map[string]interface{} `json:"data"`
+}
+
+type searchToken struct {
+	text       string // folded
+	start, end int
+}
+
+type searchDoc struct {
+	result SearchResult
+	tokens []searchToken
+}
+
+// searchIndex is an in-memory index of every searchable name. The data set is
+// a few thousand names at most, so a linear scan beats keeping an inverted
+// index in sync, and nothing depends on MySQL FULLTEXT or the face service
+// being reachable.
+type searchIndex struct {
+	mu        sync.Mutex
+	docs      []searchDoc
+	faces     []searchDoc // last good face list, kept while the face service is down
+	builtAt   time.Time
+	facesLive bool
+	building  chan struct{} // closed when the running rebuild ends, nil if none
+}
+
+var searchIdx = &searchIndex{}
+
+// Search finds logs, users and faces whose names fuzzily match query. It is
+// case, diacritic and typo tolerant; every query word has to match. types
+// limits the result types, empty means all.
+func Search(query string, types []string, limit int) ([]SearchResult, bool, error) {
+	docs, facesLive, err := searchIdx.snapshot()
+	if err != nil {
+		return nil, false, err
+	}
+
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return []SearchResult{}, facesLive, nil
+	}
+
+	wanted := make(map[string]bool)
+	for _, t := range types {
+		wanted[t] = true
+	}
+
+	results := []SearchResult{}
+	for _, doc := range docs {
+		if len(wanted) > 0 && !wanted[doc.result.Type] {
+			continue
+		}
+		score, spans, ok := matchDoc(terms, doc.tokens)
+		if !ok {
+			continue
+		}
+		r := doc.result
+		r.Score = float64(int(score*1000+0.5)) / 1000
+		r.Highlights = spans
+		results = append(results, r)
+	}
+
+	typeOrder := map[string]int{SearchTypeLog: 0, SearchTypeUser: 1, SearchTypeFace: 2}
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Type != b.Type {
+			return typeOrder[a.Type] < typeOrder[b.Type]
+		}
+		return a.Title < b.Title
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, facesLive, nil
+}
+
+// snapshot returns the indexed documents, rebuilding them when older than
+// searchRefresh. The lock only guards the index: it is not held while the
+// database and the face service are queried. While one caller rebuilds, the
+// others get the stale documents, or wait when there are none yet.
+func (idx *searchIndex) snapshot() ([]searchDoc, bool, error) {
+	for {
+		idx.mu.Lock()
+		if idx.docs != nil && (idx.building != nil || time.Since(idx.builtAt) < searchRefresh) {
+			docs, facesLive := idx.docs, idx.facesLive
+			idx.mu.Unlock()
+			return docs, facesLive, nil
+		}
+		if building := idx.building; building != nil {
+			idx.mu.Unlock()
+			<-building
+			continue
+		}
+		done := make(chan struct{})
+		idx.building = done
+		idx.mu.Unlock()
+		return idx.rebuild(done)
+	}
+}
+
+// rebuild loads the documents and stores them in the index. done is closed
+// when it returns.
+func (idx *searchIndex) rebuild(done chan struct{}) ([]searchDoc, bool, error) {
+	defer func() {
+		idx.mu.Lock()
+		idx.building = nil
+		idx.mu.Unlock()
+		close(done)
+	}()
+
+	docs, err := loadSearchDocs()
+	if err != nil {
+		return nil, false, err
+	}
+	faces, faceErr := fetchFaceDocs()
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if faceErr != nil {
+		log.Printf("[SEARCH] Face service unavailable, using last known faces: %v", faceErr)
+		idx.facesLive = false
+	} else {
+		idx.faces = faces
+		idx.facesLive = true
+	}
+
+	idx.docs = append(docs, idx.faces...)
+	idx.builtAt = time.Now()
+	return idx.docs, idx.facesLive, nil
+}
+
+func loadSearchDocs() ([]searchDoc, error) {
+	var names []struct {
+		Name       string
+		Detections int64
+		LastSeen   time.Time
+	}
+	if err := config.DB.Model(&models.Log{}).
+		Select("name, COUNT(*) AS detections, MAX(occurred_at) AS last_seen").
+		Where("name <> ?", "Unknown").Group("name").Scan(&names).Error; err != nil {
+		return nil, err
+	}
+
+	var users []models.User
+	if err := config.DB.Select("id", "username", "role").Find(&users).Error; err != nil {
+		return nil, err
+	}
+
+	docs := make([]searchDoc, 0, len(names)+len(users))
+	for _, n := range names {
+		docs = append(docs, newSearchDoc(SearchResult{
+			Type:  SearchTypeLog,
+			ID:    n.Name,
+			Title: n.Name,
+			Data: map[string]interface{}{
+				"detections": n.Detections,
+				"last_seen":  n.LastSeen.In(config.SiteLocation),
+			},
+		}))
+	}
+	for _, u := range users {
+		docs = append(docs, newSearchDoc(SearchResult{
+			Type:  SearchTypeUser,
+			ID:    strconv.FormatUint(uint64(u.ID), 10),
+			Title: u.Username,
+			Data:  map[string]interface{}{"role": u.Role},
+		}))
+	}
+	return docs, nil
+}
+
+func fetchFaceDocs() ([]searchDoc, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	users, err := facesvc.Default().Users(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make([]searchDoc, 0, len(users))
+	for _, u := range users {
+		docs = append(docs, newSearchDoc(SearchResult{
+			Type:  SearchTypeFace,
+			ID:    u.Name,
+			Title: u.Name,
+			Data:  map[string]interface{}{"sample_count": u.SampleCount},
+		}))
+	}
+	return docs, nil
+}
+
+func newSearchDoc(r SearchResult) searchDoc {
+	return searchDoc{result: r, tokens: tokenize(r.Title)}
+}
+
+var foldTransformer = transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+// foldRune lowercases r and strips its diacritics, so "É" becomes "e".
+func foldRune(r rune) string {
+	folded, _, err := transform.String(foldTransformer, string(r))
+	if err != nil {
+		folded = string(r)
+	}
+	return strings.ToLower(folded)
+}
+
+// tokenize splits s into folded words, remembering where each word is in s.
+func tokenize(s string) []searchToken {
+	var tokens []searchToken
+	var b strings.Builder
+	start := -1
+	i := 0
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			b.WriteString(foldRune(r))
+		} else if start >= 0 {
+			tokens = append(tokens, searchToken{b.String(), start, i})
+			b.Reset()
+			start = -1
+		}
+		i++
+	}
+	if start >= 0 {
+		tokens = append(tokens, searchToken{b.String(), start, i})
+	}
+	return tokens
+}
+
+// matchDoc scores how well every query term matches some word of a document.
+// The score is the mean of the best match per term.
+func matchDoc(terms, tokens []searchToken) (float64, []SearchSpan, bool) {
+	var total float64
+	var spans []SearchSpan
+	used := make(map[int]bool)
+	for _, term := range terms {
+		best, bestIdx := 0.0, -1
+		for i, tok := range tokens {
+			if s := matchTerm(term.text, tok.text); s > best {
+				best, bestIdx = s, i
+			}
+		}
+		if bestIdx < 0 {
+			return 0, nil, false
+		}
+		total += best
+		if !used[bestIdx] {
+			used[bestIdx] = true
+			spans = append(spans, SearchSpan{tokens[bestIdx].start, tokens[bestIdx].end})
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+	return total / float64(len(terms)), spans, true
+}
+
+// matchTerm scores a folded query term against a folded word: exact, prefix
+// (still typing), substring, then a typo-tolerant match on the whole word or
+// its prefix. 0 means no match.
+func matchTerm(term, word string) float64 {
+	switch {
+	case term == word:
+		return 1
+	case strings.HasPrefix(word, term):
+		return 0.9
+	case len([]rune(term)) >= 3 && strings.Contains(word, term):
+		return 0.75
+	}
+
+	maxEdits := allowedEdits(term)
+	if maxEdits == 0 {
+		return 0
+	}
+	if d := editDistance(term, word); d <= maxEdits {
+		return 0.85 - 0.15*float64(d)
+	}
+	if w := []rune(word); len(w) > len([]rune(term)) {
+		if d := editDistance(term, string(w[:len([]rune(term))])); d <= maxEdits {
+			return 0.7 - 0.15*float64(d)
+		}
+	}
+	return 0
+}
+
+func allowedEdits(term string) int {
+	switch n := len([]rune(term)); {
+	case n < 3:
+		return 0
+	case n <= 5:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// editDistance is the optimal string alignment distance: insertions,
+// deletions, substitutions and swaps of adjacent letters each cost one.
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	prev2 := make([]int, len(t)+1)
+	prev := make([]int, len(t)+1)
+	cur := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(t)]
+}
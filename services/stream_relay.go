@@ -0,0 +1,303 @@
+package services
+
+import (
+	"comproBackend/facesvc"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"sync"
+	"time"
+)
+
+const streamBackoff = 30 * time.Second
+
+var (
+	streamIdleOnce sync.Once
+	streamIdle     time.Duration
+)
+
+// cameraStreamIdle reads CAMERA_STREAM_IDLE_TIMEOUT on first use: a camera
+// stream that delivers no frame for this long is reconnected, 0 = never.
+func cameraStreamIdle() time.Duration {
+	streamIdleOnce.Do(func() {
+		streamIdle = envDuration("CAMERA_STREAM_IDLE_TIMEOUT", 15*time.Second)
+	})
+	return streamIdle
+}
+
+// StreamOptions select the variant of the camera stream a viewer receives.
+type StreamOptions struct {
+	FPS   float64 // frame rate limit, 0 = as received
+	Width int     // scale frames down to this width, 0 = original size
+}
+
+// StreamViewer receives the frames of one camera. It only ever holds the
+// newest frame, so a viewer that cannot keep up skips frames without slowing
+// down the others.
+type StreamViewer struct {
+	relay     *streamRelay
+	frames    chan *relayFrame
+	interval  time.Duration
+	width     int
+	last      time.Time // guarded by relay.mu
+	closeOnce sync.Once
+}
+
+// relayFrame is a received frame. Scaled variants are made on demand by the
+// first viewer that needs them, outside the upstream reader, and shared.
+type relayFrame struct {
+	data   []byte
+	mu     sync.Mutex
+	scaled map[int][]byte
+}
+
+func (f *relayFrame) scaledTo(width int) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if data, ok := f.scaled[width]; ok {
+		return data
+	}
+	data, err := makeThumbnail(f.data, width)
+	if err != nil {
+		data = f.data
+	}
+	if f.scaled == nil {
+		f.scaled = make(map[int][]byte)
+	}
+	f.scaled[width] = data
+	return data
+}
+
+// streamRelay is the single upstream connection for one camera, shared by
+// all of its viewers. It is closed when the last viewer leaves.
+type streamRelay struct {
+	cameraID string
+	name     string // for logs
+	cancel   context.CancelFunc
+	idle     time.Duration // reconnect when no frame arrives for this long, 0 = never
+
+	mu        sync.Mutex
+	viewers   map[*StreamViewer]struct{}
+	connected bool
+	lastFrame []byte
+	lastAt    time.Time
+}
+
+type relayRegistry struct {
+	mu     sync.Mutex
+	relays map[string]*streamRelay
+}
+
+var cameraStreams = &relayRegistry{relays: make(map[string]*streamRelay)}
+
+// WatchCamera joins the stream of cameraID ("" for the default camera),
+// connecting to the camera service if nobody is watching it yet. Close the
+// viewer when done.
+func WatchCamera(cameraID string, opts StreamOptions) *StreamViewer {
+	v := &StreamViewer{frames: make(chan *relayFrame, 1), width: opts.Width}
+	if opts.FPS > 0 {
+		v.interval = time.Duration(float64(time.Second) / opts.FPS)
+	}
+
+	cameraStreams.mu.Lock()
+	defer cameraStreams.mu.Unlock()
+	r, ok := cameraStreams.relays[cameraID]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		r = &streamRelay{cameraID: cameraID, name: cameraName(cameraID), cancel: cancel, idle: cameraStreamIdle(),
+			viewers: make(map[*StreamViewer]struct{})}
+		cameraStreams.relays[cameraID] = r
+		go r.run(ctx)
+	}
+	r.mu.Lock()
+	r.viewers[v] = struct{}{}
+	r.mu.Unlock()
+	v.relay = r
+	return v
+}
+
+func cameraName(cameraID string) string {
+	if cameraID == "" {
+		return "default"
+	}
+	return cameraID
+}
+
+// Next waits for the next frame. It returns nil when ctx ends or, if timeout
+// is positive, when no frame arrives in time.
+func (v *StreamViewer) Next(ctx context.Context, timeout time.Duration) []byte {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case frame := <-v.frames:
+		if v.width > 0 {
+			return frame.scaledTo(v.width)
+		}
+		return frame.data
+	case <-ctx.Done():
+		return nil
+	case <-expired:
+		return nil
+	}
+}
+
+// Connected reports whether the camera stream is currently being received.
+func (v *StreamViewer) Connected() bool {
+	v.relay.mu.Lock()
+	defer v.relay.mu.Unlock()
+	return v.relay.connected
+}
+
+// Close leaves the stream; the last viewer closes the upstream connection.
+func (v *StreamViewer) Close() {
+	v.closeOnce.Do(func() {
+		cameraStreams.mu.Lock()
+		defer cameraStreams.mu.Unlock()
+		r := v.relay
+		r.mu.Lock()
+		delete(r.viewers, v)
+		empty := len(r.viewers) == 0
+		r.mu.Unlock()
+		if empty {
+			r.cancel()
+			for id, other := range cameraStreams.relays {
+				if other == r {
+					delete(cameraStreams.relays, id)
+				}
+			}
+		}
+	})
+}
+
+// latestFrame returns the newest frame of cameraID if someone is watching it
+// and the frame is not older than maxAge.
+func latestFrame(cameraID string, maxAge time.Duration) []byte {
+	cameraStreams.mu.Lock()
+	r, ok := cameraStreams.relays[cameraID]
+	cameraStreams.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.lastFrame != nil && time.Since(r.lastAt) <= maxAge {
+		return r.lastFrame
+	}
+	return nil
+}
+
+// distribute offers frame to every viewer due for one.
+func (r *streamRelay) distribute(data []byte) {
+	now := time.Now()
+	frame := &relayFrame{data: data}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastFrame, r.lastAt = data, now
+
+	for v := range r.viewers {
+		if v.interval > 0 && now.Sub(v.last) < v.interval {
+			continue
+		}
+		v.last = now
+
+		// replace a frame the viewer has not picked up yet
+		select {
+		case <-v.frames:
+		default:
+		}
+		select {
+		case v.frames <- frame:
+		default:
+		}
+	}
+}
+
+func (r *streamRelay) setConnected(connected bool) {
+	r.mu.Lock()
+	r.connected = connected
+	if !connected {
+		r.lastFrame = nil
+	}
+	r.mu.Unlock()
+}
+
+// run keeps reading the stream until ctx is cancelled, reconnecting with
+// backoff while the camera is unavailable.
+func (r *streamRelay) run(ctx context.Context) {
+	log.Printf("[STREAM] Opening camera stream %s", r.name)
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := r.read(ctx)
+		r.setConnected(false)
+		if ctx.Err() != nil {
+			log.Printf("[STREAM] Closed camera stream %s, no viewers left", r.name)
+			return
+		}
+		if time.Since(started) > time.Minute {
+			backoff = time.Second
+		}
+		log.Printf("[STREAM] Camera stream %s ended: %v, reconnecting in %s", r.name, err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		if backoff *= 2; backoff > streamBackoff {
+			backoff = streamBackoff
+		}
+	}
+}
+
+// read receives one connection until it fails, ctx ends or no frame arrives
+// for r.idle.
+func (r *streamRelay) read(ctx context.Context) error {
+	// the watchdog cancels the request, which unblocks a read on a hung
+	// or half-open connection
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var watchdog *time.Timer
+	if r.idle > 0 {
+		watchdog = time.AfterFunc(r.idle, cancel)
+		defer watchdog.Stop()
+	}
+
+	stream, err := facesvc.Default().Stream(streamCtx, r.cameraID)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	_, params, err := mime.ParseMediaType(stream.ContentType)
+	if err != nil || params["boundary"] == "" {
+		return fmt.Errorf("camera stream is not multipart: %q", stream.ContentType)
+	}
+
+	r.setConnected(true)
+	reader := multipart.NewReader(stream, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == nil {
+			var data []byte
+			if data, err = io.ReadAll(io.LimitReader(part, maxFrameSize)); err == nil && len(data) > 0 {
+				r.distribute(data)
+			}
+		}
+		if err != nil {
+			if ctx.Err() == nil && streamCtx.Err() != nil {
+				return fmt.Errorf("no frame for %s", r.idle)
+			}
+			return err
+		}
+		if watchdog != nil {
+			watchdog.Reset(r.idle)
+		}
+	}
+}
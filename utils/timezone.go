@@ -0,0 +1,31 @@
+package utils
+
+import "time"
+
+// LocalMidnight returns the first instant of the calendar day y-m-d in loc.
+// On days where a DST change skips midnight this is the moment the clocks jump,
+// not a normalized time on the neighbouring day.
+func LocalMidnight(y int, m time.Month, d int, loc *time.Location) time.Time {
+	t := time.Date(y, m, d, 0, 0, 0, 0, loc)
+	if ty, tm, td := t.Date(); ty != y || tm != m || td != d {
+		// Resolved to the evening before the gap; the day starts where that zone period ends.
+		_, end := t.ZoneBounds()
+		return end
+	}
+	if t.Hour() != 0 || t.Minute() != 0 {
+		// Resolved to after the gap; the day starts where this zone period starts.
+		start, _ := t.ZoneBounds()
+		return start
+	}
+	return t
+}
+
+// DayRange returns the half-open interval [start, end) covering the calendar
+// days from first to last inclusive in loc. Only the dates of first and last
+// are used, so DST days correctly span 23 or 25 hours.
+func DayRange(first, last time.Time, loc *time.Location) (time.Time, time.Time) {
+	fy, fm, fd := first.Date()
+	ly, lm, ld := last.Date()
+	ny, nm, nd := time.Date(ly, lm, ld+1, 12, 0, 0, 0, time.UTC).Date()
+	return LocalMidnight(fy, fm, fd, loc), LocalMidnight(ny, nm, nd, loc)
+}
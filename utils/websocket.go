@@ -2,148 +2,525 @@ package utils
 
 import (
 	"comproBackend/config"
-	"comproBackend/models"
+	"comproBackend/events"
+	"comproBackend/services"
 	"encoding/json"
+	"errors"
 	"log"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 )
 
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+	CheckOrigin:  checkWsOrigin,
+	Subprotocols: []string{wsSubprotocol},
 }
 
+// Client is one WebSocket connection and the user it was authenticated as.
 type Client struct {
-	conn *websocket.Conn
-	send chan []byte
+	conn     *websocket.Conn
+	writeMu  sync.Mutex
+	send     chan hubMessage
+	sub      *subscription
+	resume   *uint64       // last_seq the client reconnected with
+	commands chan struct{} // commands in progress
+	cfg      hubSettings
+	UserID   uint
+	Username string
+	Role     string
+
+	// owned by the hub goroutine; read by writePump after send is closed
+	closeCode int
+	closeText string
+	dropped   int
 }
 
+// hubMessage is an event encoded for every protocol version, with what
+// subscriptions are matched on.
+type hubMessage struct {
+	seq      uint64
+	topic    string
+	cameraID string
+	name     string
+	legacy   []byte
+	envelope []byte
+}
+
+// ClientManager owns the set of connected clients. Only the Start goroutine
+// touches clients and closes send channels, so a client is removed and its
+// channel closed exactly once.
 type ClientManager struct {
 	clients    map[*Client]bool
-	broadcast  chan []byte
 	register   chan *Client
 	unregister chan *Client
-	mutex      sync.RWMutex
+	done       chan struct{}
+	stopOnce   sync.Once
+
+	// nil uses WS_BACKPLANE and the WS_* hub settings
+	backplane Backplane
+	settings  *hubSettings
 }
 
-var Manager = ClientManager{
-	clients:    make(map[*Client]bool),
-	broadcast:  make(chan []byte, 256),
-	register:   make(chan *Client),
-	unregister: make(chan *Client),
+var Manager = newClientManager()
+
+func newClientManager() *ClientManager {
+	return &ClientManager{
+		clients:    make(map[*Client]bool),
+		register:   make(chan *Client),
+		unregister: make(chan *Client),
+		done:       make(chan struct{}),
+	}
+}
+
+func (m *ClientManager) hubBackplane() Backplane {
+	if m.backplane != nil {
+		return m.backplane
+	}
+	return wsBackplane()
+}
+
+func (m *ClientManager) config() hubSettings {
+	if m.settings != nil {
+		return *m.settings
+	}
+	return wsHubSettings()
 }
 
 func (m *ClientManager) Start() {
+	go forwardLogEvents(m.done)
+
+	backplane := m.hubBackplane()
+	messages := backplane.Messages()
 	for {
 		select {
+		case <-m.done:
+			backplane.Close()
+			for client := range m.clients {
+				m.remove(client, websocket.CloseGoingAway, "server shutting down")
+			}
+			return
+
 		case client := <-m.register:
-			m.mutex.Lock()
 			m.clients[client] = true
-			m.mutex.Unlock()
 			log.Printf("Client registered. Total clients: %d", len(m.clients))
 
 		case client := <-m.unregister:
-			m.mutex.Lock()
-			if _, ok := m.clients[client]; ok {
-				delete(m.clients, client)
-				close(client.send)
-			}
-			m.mutex.Unlock()
+			m.remove(client, websocket.CloseNormalClosure, "")
 			log.Printf("Client unregistered. Total clients: %d", len(m.clients))
 
-		case message := <-m.broadcast:
-			m.mutex.RLock()
+		case message, ok := <-messages:
+			if !ok {
+				messages = nil
+				continue
+			}
+			Journal().record(message)
 			for client := range m.clients {
+				if !client.sub.matches(message) {
+					continue
+				}
 				select {
 				case client.send <- message:
 				default:
-					close(client.send)
-					delete(m.clients, client)
+					m.slowClient(client)
 				}
 			}
-			m.mutex.RUnlock()
 		}
 	}
 }
 
-func BroadcastEvent(event map[string]interface{}) {
-	data, err := json.Marshal(event)
-	if err != nil {
-		log.Println("Failed to marshal event:", err)
-		return
+// Stop disconnects every client and ends Start.
+func (m *ClientManager) Stop() {
+	m.stopOnce.Do(func() { close(m.done) })
+}
+
+// Register adds a client; false means the hub has stopped.
+func (m *ClientManager) Register(client *Client) bool {
+	select {
+	case m.register <- client:
+		return true
+	case <-m.done:
+		return false
 	}
+}
 
+// Unregister removes a client if it is still connected.
+func (m *ClientManager) Unregister(client *Client) {
 	select {
-	case Manager.broadcast <- data:
-	default:
-		log.Println("Broadcast channel full, dropping message")
+	case m.unregister <- client:
+	case <-m.done:
+	}
+}
+
+// remove closes the client's send channel; its writePump then sends a close
+// frame with code and text and closes the connection.
+func (m *ClientManager) remove(client *Client, code int, text string) {
+	if !m.clients[client] {
+		return
+	}
+	delete(m.clients, client)
+	client.closeCode, client.closeText = code, text
+	close(client.send)
+}
+
+func (m *ClientManager) slowClient(client *Client) {
+	if m.config().SlowClientPolicy == SlowClientDrop {
+		if client.dropped++; client.dropped == 1 {
+			log.Printf("Client %s is too slow, dropping events", client.Username)
+		}
+		return
+	}
+	log.Printf("Client %s is too slow, disconnecting", client.Username)
+	m.remove(client, websocket.ClosePolicyViolation, "too slow, reconnect with last_seq")
+}
+
+// Publish sends e through the backplane to the clients of every instance
+// subscribed to its topic, encoded in the protocol version each negotiated.
+func Publish(e events.Envelope) {
+	if err := wsBackplane().Publish(e); err != nil {
+		log.Println("Failed to publish event:", err)
+	}
+}
+
+func encodeHubMessage(e events.Envelope) (hubMessage, error) {
+	envelope, err := json.Marshal(e)
+	if err != nil {
+		return hubMessage{}, err
+	}
+	legacy, err := e.Legacy()
+	if err != nil {
+		return hubMessage{}, err
+	}
+
+	var attrs struct {
+		Payload struct {
+			CameraID string `json:"camera_id"`
+			Name     string `json:"name"`
+		} `json:"payload"`
+	}
+	_ = json.Unmarshal(envelope, &attrs)
+	return hubMessage{
+		seq:      e.Seq,
+		topic:    topicForEvent(e.Type),
+		cameraID: attrs.Payload.CameraID,
+		name:     attrs.Payload.Name,
+		legacy:   legacy,
+		envelope: envelope,
+	}, nil
+}
+
+// PublishCameraEvent passes a message from the camera service event stream
+// on to clients.
+func PublishCameraEvent(raw []byte) {
+	e, err := events.FromCameraEvent(raw)
+	if err != nil {
+		log.Println("Ignoring camera event:", err)
+		return
 	}
+	Publish(e)
 }
 
+// WsHandler authenticates the caller before upgrading; see authenticateWs.
+// The protocol query parameter picks the message format, 1 (default) or 2.
+// A client reconnecting with last_seq first receives the events it missed.
 func WsHandler(c *gin.Context) {
+	Manager.ServeWs(c)
+}
+
+// ServeWs connects a client to this hub, see WsHandler.
+func (m *ClientManager) ServeWs(c *gin.Context) {
+	if !checkWsOrigin(c.Request) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Origin not allowed"})
+		return
+	}
+
+	protocol := events.ProtocolV1
+	if v := c.Query("protocol"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || !slices.Contains(events.SupportedProtocols, n) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported protocol version", "supported": events.SupportedProtocols})
+			return
+		}
+		protocol = n
+	}
+
+	var resume *uint64
+	if v := c.Query("last_seq"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid last_seq"})
+			return
+		}
+		resume = &n
+	}
+
+	identity, err := authenticateWs(c.Request)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Println("Upgrade error:", err)
 		return
 	}
 
+	cfg := m.config()
 	client := &Client{
-		conn: conn,
-		send: make(chan []byte, 256),
+		conn:     conn,
+		send:     make(chan hubMessage, cfg.SendBuffer),
+		sub:      newSubscription(identity.Role, protocol),
+		commands: make(chan struct{}, maxPendingCommands),
+		resume:   resume,
+		cfg:      cfg,
+		UserID:   identity.UserID,
+		Username: identity.Username,
+		Role:     identity.Role,
 	}
 
-	Manager.register <- client
+	if !m.Register(client) {
+		_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(cfg.WriteTimeout))
+		conn.Close()
+		return
+	}
 
-	go client.writePump()
+	log.Printf("Client connected: %s (%s, protocol %d)", client.Username, client.Role, protocol)
+	if protocol >= events.ProtocolV2 {
+		client.sendWelcome()
+	}
 
-	log.Println("Client connected")
+	go client.writePump()
 
 	defer func() {
-		Manager.unregister <- client
+		m.Unregister(client)
 		conn.Close()
 	}()
 
+	// a client that neither sends nor answers pings within PongTimeout is dead
+	conn.SetReadLimit(wsMaxMessageSize)
+	_ = conn.SetReadDeadline(time.Now().Add(cfg.PongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(cfg.PongTimeout))
+	})
+
 	for {
 		_, message, err := conn.ReadMessage()
 		if err != nil {
-			log.Println("Read error:", err)
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) && !errors.Is(err, net.ErrClosed) {
+				log.Println("Read error:", err)
+			}
 			break
 		}
+		_ = conn.SetReadDeadline(time.Now().Add(cfg.PongTimeout))
 
-		log.Println("Received from client:", string(message))
+		log.Printf("Received from client %s: %s", client.Username, message)
 
-		var logEntry models.Log
-		if err := json.Unmarshal(message, &logEntry); err != nil {
-			log.Println("JSON parse error:", err)
+		var ctl wsControl
+		if err := json.Unmarshal(message, &ctl); err == nil && ctl.Action != "" {
+			client.handleControl(ctl)
+			continue
+		}
+
+		if !canWriteLogs(client.Role) {
+			client.replyError("Insufficient permissions to send logs", nil)
+			continue
+		}
 
-			_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"error":"invalid JSON"}`))
+		var input services.LogInput
+		if err := json.Unmarshal(message, &input); err != nil {
+			log.Println("JSON parse error:", err)
+			client.replyError("invalid JSON", nil)
 			continue
 		}
 
-		if err := config.DB.Create(&logEntry).Error; err != nil {
-			log.Println("DB error:", err)
-			_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"error":"db failed"}`))
+		logEntry, created, err := services.IngestLog(input)
+		if err != nil {
+			var validationErr *services.ValidationError
+			switch {
+			case errors.As(err, &validationErr):
+				client.replyError("invalid log", validationErr.Fields)
+			case errors.Is(err, services.ErrIdempotencyConflict):
+				client.replyError(err.Error(), nil)
+			default:
+				log.Println("DB error:", err)
+				client.replyError("db failed", nil)
+			}
 			continue
 		}
 
-		_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"status":"saved"}`))
+		ack := events.AckPayload{Status: "saved", ID: logEntry.ID, Duplicate: !created}
+		client.reply(events.TypeAck, ack, map[string]interface{}{"status": ack.Status, "id": ack.ID, "duplicate": ack.Duplicate})
+	}
+}
+
+// handleControl answers a hello, subscription request or command.
+func (c *Client) handleControl(ctl wsControl) {
+	switch ctl.Action {
+	case "hello":
+		version := 0
+		for _, v := range ctl.Versions {
+			if slices.Contains(events.SupportedProtocols, v) && v > version {
+				version = v
+			}
+		}
+		if version == 0 {
+			c.replyError("no supported protocol version", events.SupportedProtocols)
+			return
+		}
+		c.sub.setProtocol(version)
+		c.sendWelcome()
+	case "subscribe", "unsubscribe":
+		if err := c.sub.apply(ctl); err != nil {
+			c.replyError(err.Error(), nil)
+			return
+		}
+		fallthrough
+	case "subscriptions":
+		snapshot := c.sub.snapshot()
+		c.reply(events.TypeSubscriptions, snapshot, map[string]interface{}{"type": events.TypeSubscriptions, "data": snapshot})
+	case "command":
+		c.runCommand(ctl)
+	default:
+		c.replyError("unknown action, use hello, subscribe, unsubscribe, subscriptions or command", nil)
+	}
+}
+
+func (c *Client) sendWelcome() {
+	welcome := events.WelcomePayload{
+		Protocol:        c.sub.protocol(),
+		Seq:             Journal().Seq(),
+		Supported:       events.SupportedProtocols,
+		PayloadVersions: events.PayloadVersions,
+		Username:        c.Username,
+		Role:            c.Role,
 	}
+	c.reply(events.TypeWelcome, welcome, map[string]interface{}{"type": events.TypeWelcome, "data": welcome})
 }
 
+func (c *Client) replyError(message string, details interface{}) {
+	legacy := map[string]interface{}{"error": message}
+	if details != nil {
+		legacy["details"] = details
+	}
+	c.reply(events.TypeError, events.ErrorPayload{Message: message, Details: details}, legacy)
+}
+
+// reply answers this client only: legacy for protocol 1, an envelope of
+// eventType otherwise.
+func (c *Client) reply(eventType string, payload interface{}, legacy map[string]interface{}) {
+	var data []byte
+	if c.sub.protocol() >= events.ProtocolV2 {
+		data, _ = json.Marshal(events.New(eventType, events.SourceBackend, payload))
+	} else {
+		data, _ = json.Marshal(legacy)
+	}
+	_ = c.write(data)
+}
+
+// write serializes writes from the read loop and writePump, since a
+// websocket.Conn supports only one concurrent writer.
+func (c *Client) write(data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(c.cfg.WriteTimeout))
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// forwardLogEvents publishes every newly stored log as a "log" event until
+// done is closed.
+func forwardLogEvents(done <-chan struct{}) {
+	for {
+		logEvents, unsubscribe := services.SubscribeLogEvents(256)
+	forward:
+		for {
+			select {
+			case <-done:
+				unsubscribe()
+				return
+			case le, ok := <-logEvents:
+				if !ok {
+					break forward
+				}
+				if le.Type == services.LogEventCreated {
+					l := le.Log
+					l.OccurredAt = l.OccurredAt.In(config.SiteLocation)
+					e := events.New(events.TypeLog, events.SourceBackend, l)
+					e.OccurredAt = le.Log.OccurredAt.UTC()
+					Publish(e)
+				}
+			}
+		}
+		unsubscribe()
+		log.Println("WebSocket log forwarder fell behind, resubscribing")
+	}
+}
+
+// writePump sends queued events and keepalive pings. It ends when the hub
+// closes send, sending the close frame the hub asked for, or on a write error.
 func (c *Client) writePump() {
+	cfg := c.cfg
+	ping := time.NewTicker(cfg.PingInterval)
 	defer func() {
+		ping.Stop()
 		c.conn.Close()
 	}()
 
-	for message := range c.send {
-		if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
-			log.Println("Write error:", err)
-			return
+	// the client is registered already, so events published while the
+	// journal is read arrive on c.send as well and are skipped there
+	var replayedUpTo uint64
+	if c.resume != nil {
+		missed, head, first, ok := Journal().since(*c.resume)
+		if !ok {
+			resync := events.ResyncPayload{LastSeq: *c.resume, FirstSeq: first, Seq: head, Reason: "gap too large"}
+			c.reply(events.TypeResync, resync, map[string]interface{}{"type": events.TypeResync, "data": resync})
 		}
+		for _, message := range missed {
+			if !c.sub.matches(message) {
+				continue
+			}
+			if err := c.writeMessage(message); err != nil {
+				log.Println("Write error:", err)
+				return
+			}
+		}
+		replayedUpTo = head
+	}
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			if !ok {
+				if c.closeCode != 0 {
+					_ = c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(c.closeCode, c.closeText), time.Now().Add(cfg.WriteTimeout))
+				}
+				return
+			}
+			if message.seq <= replayedUpTo {
+				continue
+			}
+			if err := c.writeMessage(message); err != nil {
+				log.Println("Write error:", err)
+				return
+			}
+		case <-ping.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(cfg.WriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *Client) writeMessage(message hubMessage) error {
+	data := message.legacy
+	if c.sub.protocol() >= events.ProtocolV2 {
+		data = message.envelope
 	}
+	return c.write(data)
 }
@@ -0,0 +1,366 @@
+package utils
+
+import (
+	"comproBackend/events"
+	"comproBackend/middleware"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+type testHub struct {
+	manager *ClientManager
+	server  *httptest.Server
+	stopped chan struct{}
+	logs    *logBuffer
+}
+
+// logBuffer collects the hub's log output.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) contains(s string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.Contains(b.buf.String(), s)
+}
+
+// startTestHub runs a hub with its own memory backplane behind an httptest
+// server.
+func startTestHub(t *testing.T, cfg hubSettings) *testHub {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	logOutput := log.Writer()
+	logs := &logBuffer{}
+	log.SetOutput(logs)
+
+	m := newClientManager()
+	m.backplane = NewMemoryBackplane()
+	m.settings = &cfg
+	h := &testHub{manager: m, stopped: make(chan struct{}), logs: logs}
+	go func() {
+		m.Start()
+		close(h.stopped)
+	}()
+
+	router := gin.New()
+	router.GET("/ws", m.ServeWs)
+	h.server = httptest.NewServer(router)
+	t.Cleanup(func() {
+		h.stop(t)
+		h.server.Close()
+		log.SetOutput(logOutput)
+	})
+	return h
+}
+
+// waitLog waits until the hub logged s.
+func (h *testHub) waitLog(t *testing.T, s string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !h.logs.contains(s) {
+		if time.Now().After(deadline) {
+			t.Fatalf("hub never logged %q", s)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (h *testHub) stop(t *testing.T) {
+	h.manager.Stop()
+	select {
+	case <-h.stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("hub did not stop")
+	}
+}
+
+// dial connects a protocol 2 client and waits for its welcome, after which it
+// is registered. readBuffer > 0 shrinks the socket receive buffer so that a
+// client that does not read backs up quickly.
+func (h *testHub) dial(t *testing.T, name string, readBuffer int) *websocket.Conn {
+	t.Helper()
+	ticket, _, err := IssueWsTicket(middleware.Identity{Username: name, Role: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialer := websocket.Dialer{HandshakeTimeout: 5 * time.Second}
+	if readBuffer > 0 {
+		dialer.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+			if err == nil {
+				err = conn.(*net.TCPConn).SetReadBuffer(readBuffer)
+			}
+			return conn, err
+		}
+	}
+	url := "ws" + strings.TrimPrefix(h.server.URL, "http") + "/ws?protocol=2&ticket=" + ticket
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial %s: %v", name, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if e := readEvent(t, conn); e.Type != events.TypeWelcome {
+		t.Fatalf("%s: first message is %q, want welcome", name, e.Type)
+	}
+	return conn
+}
+
+// publish retries while the backplane buffer is full.
+func (h *testHub) publish(t *testing.T, payload interface{}) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := h.manager.backplane.Publish(events.New(events.TypeCameraEvent, events.SourceBackend, payload))
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("publish: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+type testEvent struct {
+	Type    string `json:"type"`
+	Seq     uint64 `json:"seq"`
+	Payload struct {
+		N      int  `json:"n"`
+		Marker bool `json:"marker"`
+	} `json:"payload"`
+}
+
+func readEvent(t *testing.T, conn *websocket.Conn) testEvent {
+	t.Helper()
+	e, err := tryReadEvent(conn, 10*time.Second)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return e
+}
+
+func tryReadEvent(conn *websocket.Conn, timeout time.Duration) (testEvent, error) {
+	var e testEvent
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return e, err
+	}
+	return e, json.Unmarshal(data, &e)
+}
+
+// expectClose reads until the close frame and checks its code.
+func expectClose(conn *websocket.Conn, code int) error {
+	for {
+		_, err := tryReadEvent(conn, 10*time.Second)
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) {
+			return fmt.Errorf("want close %d, got %v", code, err)
+		}
+		if closeErr.Code != code {
+			return fmt.Errorf("want close %d, got %d", code, closeErr.Code)
+		}
+		return nil
+	}
+}
+
+func testHubSettings(policy string) hubSettings {
+	return hubSettings{
+		PingInterval:     30 * time.Second,
+		PongTimeout:      60 * time.Second,
+		WriteTimeout:     10 * time.Second,
+		SendBuffer:       8,
+		SlowClientPolicy: policy,
+	}
+}
+
+func TestHubBroadcastAndStop(t *testing.T) {
+	const clients, published = 300, 50
+	cfg := testHubSettings(SlowClientDisconnect)
+	cfg.SendBuffer = 256 // nobody is slow here, even under the race detector
+	hub := startTestHub(t, cfg)
+
+	conns := make([]*websocket.Conn, clients)
+	for i := range conns {
+		conns[i] = hub.dial(t, fmt.Sprintf("client-%d", i), 0)
+	}
+
+	var received, wg sync.WaitGroup
+	errs := make(chan error, clients)
+	for i, conn := range conns {
+		received.Add(1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := func() error {
+				defer received.Done()
+				for n := 0; n < published; n++ {
+					e, err := tryReadEvent(conn, 10*time.Second)
+					if err != nil {
+						return fmt.Errorf("client %d, event %d: %v", i, n, err)
+					}
+					if e.Payload.N != n {
+						return fmt.Errorf("client %d: got event %d, want %d", i, e.Payload.N, n)
+					}
+				}
+				return nil
+			}()
+			if err == nil {
+				err = expectClose(conn, websocket.CloseGoingAway)
+			}
+			errs <- err
+		}()
+	}
+
+	for n := 0; n < published; n++ {
+		hub.publish(t, map[string]int{"n": n})
+	}
+	received.Wait()
+	hub.stop(t)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestHubRejectsAfterStop(t *testing.T) {
+	hub := startTestHub(t, testHubSettings(SlowClientDisconnect))
+	conn := hub.dial(t, "early", 0)
+	hub.stop(t)
+	if err := expectClose(conn, websocket.CloseGoingAway); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stop is idempotent and late clients are turned away
+	hub.manager.Stop()
+	ticket, _, _ := IssueWsTicket(middleware.Identity{Username: "late", Role: "user"})
+	late, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(hub.server.URL, "http")+"/ws?protocol=2&ticket="+ticket, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer late.Close()
+	if err := expectClose(late, websocket.CloseGoingAway); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// slowClientEvents are large, so a client that does not read fills the
+// socket buffers and then its send buffer.
+const slowClientEvents = 200
+
+var slowClientBlob = strings.Repeat("x", 64<<10)
+
+// runSlowClient publishes slowClientEvents to a reading and a non-reading
+// client. Each event is published once the reading client got the previous
+// one, so only the non-reading client can fall behind.
+func runSlowClient(t *testing.T, hub *testHub) (slow *websocket.Conn) {
+	fast := hub.dial(t, "fast", 0)
+	slow = hub.dial(t, "slow", 64<<10)
+
+	for n := 0; n < slowClientEvents; n++ {
+		hub.publish(t, map[string]interface{}{"n": n, "blob": slowClientBlob})
+		if e := readEvent(t, fast); e.Payload.N != n {
+			t.Fatalf("fast client got event %d, want %d", e.Payload.N, n)
+		}
+	}
+	return slow
+}
+
+func TestSlowClientDisconnect(t *testing.T) {
+	cfg := testHubSettings(SlowClientDisconnect)
+	cfg.WriteTimeout = time.Second
+	hub := startTestHub(t, cfg)
+	slow := runSlowClient(t, hub)
+	hub.waitLog(t, "Client slow is too slow, disconnecting")
+
+	// the slow client gets what was buffered and then loses the connection
+	received := 0
+	for {
+		_, err := tryReadEvent(slow, 10*time.Second)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				t.Fatal("slow client was not disconnected")
+			}
+			break
+		}
+		received++
+	}
+	if received >= slowClientEvents {
+		t.Fatalf("slow client received all %d events", received)
+	}
+}
+
+func TestSlowClientDrop(t *testing.T) {
+	hub := startTestHub(t, testHubSettings(SlowClientDrop))
+	slow := runSlowClient(t, hub)
+	hub.waitLog(t, "Client slow is too slow, dropping events")
+
+	// the slow client skipped events but stays connected: once it reads
+	// again, new events reach it
+	marker := make(chan error, 1)
+	go func() {
+		received := 0
+		for {
+			e, err := tryReadEvent(slow, 10*time.Second)
+			if err != nil {
+				marker <- fmt.Errorf("slow client after %d events: %v", received, err)
+				return
+			}
+			if e.Payload.Marker {
+				if received >= slowClientEvents {
+					marker <- fmt.Errorf("slow client received all %d events", received)
+					return
+				}
+				marker <- nil
+				return
+			}
+			received++
+		}
+	}()
+
+	deadline := time.After(10 * time.Second)
+	for {
+		hub.publish(t, map[string]bool{"marker": true})
+		select {
+		case err := <-marker:
+			if err != nil {
+				t.Fatal(err)
+			}
+			hub.stop(t)
+			if err := expectClose(slow, websocket.CloseGoingAway); err != nil {
+				t.Fatal(err)
+			}
+			return
+		case <-deadline:
+			t.Fatal("slow client never received the marker")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
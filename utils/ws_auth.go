@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"comproBackend/middleware"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WsTicketTTL is how long a WebSocket ticket can be redeemed.
+const WsTicketTTL = 30 * time.Second
+
+// wsSubprotocol is offered by browsers, which cannot set headers on a
+// WebSocket, as "bearer, <token>".
+const wsSubprotocol = "bearer"
+
+var errWsUnauthorized = errors.New("Authentication required: use an Authorization header, the bearer subprotocol or a ticket")
+
+type wsTicket struct {
+	identity  middleware.Identity
+	expiresAt time.Time
+}
+
+var wsTickets = struct {
+	mu      sync.Mutex
+	tickets map[string]wsTicket
+}{tickets: make(map[string]wsTicket)}
+
+// IssueWsTicket returns a one-time ticket that opens a WebSocket as identity
+// within WsTicketTTL.
+func IssueWsTicket(identity middleware.Identity) (string, time.Time, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, err
+	}
+	ticket := hex.EncodeToString(buf)
+	expiresAt := time.Now().Add(WsTicketTTL)
+
+	wsTickets.mu.Lock()
+	defer wsTickets.mu.Unlock()
+	now := time.Now()
+	for t, v := range wsTickets.tickets {
+		if now.After(v.expiresAt) {
+			delete(wsTickets.tickets, t)
+		}
+	}
+	wsTickets.tickets[ticket] = wsTicket{identity: identity, expiresAt: expiresAt}
+	return ticket, expiresAt, nil
+}
+
+func redeemWsTicket(ticket string) (middleware.Identity, bool) {
+	wsTickets.mu.Lock()
+	defer wsTickets.mu.Unlock()
+	t, ok := wsTickets.tickets[ticket]
+	delete(wsTickets.tickets, ticket)
+	if !ok || time.Now().After(t.expiresAt) {
+		return middleware.Identity{}, false
+	}
+	return t.identity, true
+}
+
+// authenticateWs finds the caller of a WebSocket upgrade from, in order, the
+// Authorization header, the bearer subprotocol or a ticket query parameter.
+func authenticateWs(r *http.Request) (middleware.Identity, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			return middleware.Identity{}, errors.New("Invalid authorization header format")
+		}
+		return middleware.Authenticate(token)
+	}
+
+	if protocols := websocketProtocols(r); len(protocols) == 2 && protocols[0] == wsSubprotocol {
+		return middleware.Authenticate(protocols[1])
+	}
+
+	if ticket := r.URL.Query().Get("ticket"); ticket != "" {
+		identity, ok := redeemWsTicket(ticket)
+		if !ok {
+			return middleware.Identity{}, errors.New("Invalid or expired ticket")
+		}
+		return identity, nil
+	}
+
+	return middleware.Identity{}, errWsUnauthorized
+}
+
+func websocketProtocols(r *http.Request) []string {
+	var protocols []string
+	for _, h := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(h, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				protocols = append(protocols, p)
+			}
+		}
+	}
+	return protocols
+}
+
+var (
+	wsConfigOnce      sync.Once
+	wsAllowedOrigins  map[string]bool
+	wsLogWriteRoles   map[string]bool
+	wsAllowAllOrigins bool
+)
+
+// loadWsConfig reads WS_ALLOWED_ORIGINS and WS_LOG_WRITE_ROLES on first use,
+// after .env has been loaded.
+func loadWsConfig() {
+	wsConfigOnce.Do(func() {
+		wsAllowedOrigins = make(map[string]bool)
+		for _, o := range strings.Split(os.Getenv("WS_ALLOWED_ORIGINS"), ",") {
+			o = strings.TrimRight(strings.ToLower(strings.TrimSpace(o)), "/")
+			if o == "*" {
+				wsAllowAllOrigins = true
+			} else if o != "" {
+				wsAllowedOrigins[o] = true
+			}
+		}
+
+		roles := os.Getenv("WS_LOG_WRITE_ROLES")
+		if roles == "" {
+			roles = "service,verificator"
+		}
+		wsLogWriteRoles = make(map[string]bool)
+		for _, role := range strings.Split(roles, ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				wsLogWriteRoles[role] = true
+			}
+		}
+	})
+}
+
+// checkWsOrigin accepts clients without an Origin header (not browsers), the
+// server's own origin and the origins in WS_ALLOWED_ORIGINS.
+func checkWsOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	loadWsConfig()
+	if wsAllowAllOrigins {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return wsAllowedOrigins[strings.ToLower(u.Scheme+"://"+u.Host)]
+}
+
+// canWriteLogs reports whether role may send logs over the socket.
+func canWriteLogs(role string) bool {
+	loadWsConfig()
+	return wsLogWriteRoles[role]
+}
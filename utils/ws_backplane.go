@@ -0,0 +1,304 @@
+package utils
+
+import (
+	"comproBackend/config"
+	"comproBackend/events"
+	"comproBackend/models"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Backplane carries broadcasts between backend instances. Every instance
+// publishes to it and relays what comes out of Messages to its own clients,
+// so a client sees the same events whichever instance it is connected to.
+type Backplane interface {
+	// Publish numbers e and sends it to every instance, this one included.
+	Publish(e events.Envelope) error
+	// Messages yields the events of all instances in sequence order. It is
+	// closed after Close.
+	Messages() <-chan hubMessage
+	// Close stops delivery.
+	Close()
+}
+
+// backplaneHistory is implemented by backplanes that keep past events, which
+// the journal then loads instead of its own table.
+type backplaneHistory interface {
+	history(limit int, retention time.Duration) (messages []hubMessage, head uint64, err error)
+}
+
+const wsBackplaneBatchSize = 500
+
+var errBackplaneClosed = errors.New("backplane closed")
+
+var (
+	wsBackplaneOnce sync.Once
+	wsBackplaneImpl Backplane
+)
+
+// UseBackplane replaces the backplane chosen by WS_BACKPLANE. It has to be
+// called before Manager.Start and before anything is published.
+func UseBackplane(b Backplane) {
+	wsBackplaneImpl = b
+}
+
+// wsBackplane returns the backplane, configured from WS_BACKPLANE (memory or
+// database), WS_BACKPLANE_POLL and WS_BACKPLANE_GAP_WAIT on first use.
+func wsBackplane() Backplane {
+	wsBackplaneOnce.Do(func() {
+		if wsBackplaneImpl != nil {
+			return
+		}
+		switch kind := os.Getenv("WS_BACKPLANE"); kind {
+		case "", "memory":
+		case "database":
+			poll := envWsDuration("WS_BACKPLANE_POLL", 250*time.Millisecond)
+			gapWait := envWsDuration("WS_BACKPLANE_GAP_WAIT", 2*time.Second)
+			b, err := NewDBBackplane(config.DB, poll, gapWait, wsJournalRetention())
+			if err == nil {
+				wsBackplaneImpl = b
+				return
+			}
+			log.Printf("Warning: failed to start database backplane, using memory: %v", err)
+		default:
+			log.Printf("Warning: invalid WS_BACKPLANE %q, using memory", kind)
+		}
+		wsBackplaneImpl = NewMemoryBackplane()
+	})
+	return wsBackplaneImpl
+}
+
+// memoryBackplane serves a single instance.
+type memoryBackplane struct {
+	mu       sync.Mutex
+	seq      uint64
+	messages chan hubMessage
+	closed   bool
+}
+
+// NewMemoryBackplane returns a backplane that only reaches this instance.
+// Numbering continues from the journal.
+func NewMemoryBackplane() Backplane {
+	return &memoryBackplane{messages: make(chan hubMessage, 256)}
+}
+
+func (b *memoryBackplane) Publish(e events.Envelope) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return errBackplaneClosed
+	}
+	if b.seq == 0 {
+		b.seq = Journal().Seq()
+	}
+
+	e.Seq = b.seq + 1
+	message, err := encodeHubMessage(e)
+	if err != nil {
+		return err
+	}
+	select {
+	case b.messages <- message:
+		b.seq = e.Seq
+		return nil
+	default:
+		return errors.New("broadcast channel full, dropping message")
+	}
+}
+
+func (b *memoryBackplane) Messages() <-chan hubMessage {
+	return b.messages
+}
+
+func (b *memoryBackplane) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.closed = true
+		close(b.messages)
+	}
+}
+
+// dbBackplane passes events through the ws_broadcasts table. Each instance
+// polls for new rows; the auto-increment ID is the sequence number, so all
+// instances agree on it.
+//
+// IDs are assigned at insert but rows become visible at commit, so instances
+// can see ID n+1 before n. A missing ID is waited for up to gapWait. It cannot
+// be told apart from a hole that will never fill, left by an insert that
+// failed or was rolled back: every such hole holds back all later events for
+// gapWait on every instance. A shorter gapWait lowers that delay, but an
+// insert that commits later than gapWait is then skipped by the instances
+// that already moved past it, and their clients never get it.
+type dbBackplane struct {
+	db        *gorm.DB
+	poll      time.Duration
+	gapWait   time.Duration
+	retention time.Duration
+	messages  chan hubMessage
+	stop      chan struct{}
+	stopOnce  sync.Once
+
+	// owned by run
+	last     uint64
+	gapSince time.Time
+}
+
+// NewDBBackplane returns a backplane shared by every instance using db. It
+// polls every poll and waits up to gapWait for a missing ID. Only events
+// published after it starts are delivered.
+func NewDBBackplane(db *gorm.DB, poll, gapWait, retention time.Duration) (Backplane, error) {
+	if db == nil {
+		return nil, errors.New("database not connected")
+	}
+	b := &dbBackplane{
+		db:        db,
+		poll:      poll,
+		gapWait:   gapWait,
+		retention: retention,
+		messages:  make(chan hubMessage, 256),
+		stop:      make(chan struct{}),
+	}
+	if err := db.Model(&models.WsBroadcast{}).Select("COALESCE(MAX(id), 0)").Scan(&b.last).Error; err != nil {
+		return nil, err
+	}
+	go b.run()
+	return b, nil
+}
+
+func (b *dbBackplane) Publish(e events.Envelope) error {
+	select {
+	case <-b.stop:
+		return errBackplaneClosed
+	default:
+	}
+
+	e.Seq = 0
+	envelope, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	legacy, err := e.Legacy()
+	if err != nil {
+		return err
+	}
+	row := models.WsBroadcast{Type: e.Type, Envelope: string(envelope), Legacy: string(legacy)}
+	return b.db.Create(&row).Error
+}
+
+func (b *dbBackplane) Messages() <-chan hubMessage {
+	return b.messages
+}
+
+func (b *dbBackplane) Close() {
+	b.stopOnce.Do(func() { close(b.stop) })
+}
+
+func (b *dbBackplane) run() {
+	defer close(b.messages)
+	poll := time.NewTicker(b.poll)
+	defer poll.Stop()
+	prune := time.NewTicker(wsJournalPrune)
+	defer prune.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-poll.C:
+			if !b.deliver() {
+				return
+			}
+		case <-prune.C:
+			if err := b.db.Where("created_at < ?", time.Now().Add(-b.retention)).Delete(&models.WsBroadcast{}).Error; err != nil {
+				log.Printf("Failed to prune WebSocket backplane: %v", err)
+			}
+		}
+	}
+}
+
+// deliver passes on the rows after b.last in ID order. A missing ID is waited
+// for up to b.gapWait before being skipped, see dbBackplane. It returns false
+// once the backplane is closed.
+func (b *dbBackplane) deliver() bool {
+	for {
+		var rows []models.WsBroadcast
+		if err := b.db.Where("id > ?", b.last).Order("id").Limit(wsBackplaneBatchSize).Find(&rows).Error; err != nil {
+			log.Printf("Failed to read WebSocket backplane: %v", err)
+			return true
+		}
+
+		for _, r := range rows {
+			if r.ID != b.last+1 {
+				if b.gapSince.IsZero() {
+					b.gapSince = time.Now()
+				}
+				if time.Since(b.gapSince) < b.gapWait {
+					return true
+				}
+				log.Printf("WebSocket backplane: events %d-%d never arrived, skipping", b.last+1, r.ID-1)
+			}
+			b.gapSince = time.Time{}
+			b.last = r.ID
+
+			message, err := decodeBroadcast(r)
+			if err != nil {
+				log.Printf("Ignoring WebSocket backplane event %d: %v", r.ID, err)
+				continue
+			}
+			select {
+			case b.messages <- message:
+			case <-b.stop:
+				return false
+			}
+		}
+		if len(rows) < wsBackplaneBatchSize {
+			return true
+		}
+	}
+}
+
+func (b *dbBackplane) history(limit int, retention time.Duration) ([]hubMessage, uint64, error) {
+	var rows []models.WsBroadcast
+	if err := b.db.Where("created_at >= ?", time.Now().Add(-retention)).
+		Order("id DESC").Limit(limit).Find(&rows).Error; err != nil {
+		return nil, 0, err
+	}
+	var head uint64
+	if err := b.db.Model(&models.WsBroadcast{}).Select("COALESCE(MAX(id), 0)").Scan(&head).Error; err != nil {
+		return nil, 0, err
+	}
+
+	messages := make([]hubMessage, 0, len(rows))
+	for i := len(rows) - 1; i >= 0; i-- {
+		message, err := decodeBroadcast(rows[i])
+		if err != nil {
+			continue
+		}
+		messages = append(messages, message)
+	}
+	return messages, head, nil
+}
+
+// decodeBroadcast rebuilds the hub message of a stored event, numbered with
+// the row ID. The payload is kept as stored.
+func decodeBroadcast(r models.WsBroadcast) (hubMessage, error) {
+	var payload json.RawMessage
+	e := events.Envelope{Payload: &payload}
+	if err := json.Unmarshal([]byte(r.Envelope), &e); err != nil {
+		return hubMessage{}, err
+	}
+	e.Seq = r.ID
+	message, err := encodeHubMessage(e)
+	if err != nil {
+		return hubMessage{}, err
+	}
+	message.legacy = []byte(r.Legacy)
+	return message, nil
+}
@@ -0,0 +1,206 @@
+package utils
+
+import (
+	"comproBackend/events"
+	"comproBackend/models"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	gormmysql "gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func testEnvelope(n int) events.Envelope {
+	return events.New(events.TypeCameraEvent, events.SourceBackend, map[string]int{"n": n})
+}
+
+// receive reads the next message of b and returns its sequence number and
+// payload n.
+func receive(t *testing.T, b Backplane) (uint64, int) {
+	t.Helper()
+	select {
+	case m, ok := <-b.Messages():
+		if !ok {
+			t.Fatal("backplane closed")
+		}
+		var e struct {
+			Seq     uint64 `json:"seq"`
+			Payload struct {
+				N int `json:"n"`
+			} `json:"payload"`
+		}
+		if err := json.Unmarshal(m.envelope, &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Seq != m.seq {
+			t.Fatalf("envelope seq %d, message seq %d", e.Seq, m.seq)
+		}
+		return m.seq, e.Payload.N
+	case <-time.After(10 * time.Second):
+		t.Fatal("no message")
+	}
+	return 0, 0
+}
+
+// expectNothing checks that b delivers nothing for d.
+func expectNothing(t *testing.T, b Backplane, d time.Duration) {
+	t.Helper()
+	select {
+	case m := <-b.Messages():
+		t.Fatalf("unexpected message %d", m.seq)
+	case <-time.After(d):
+	}
+}
+
+func TestMemoryBackplane(t *testing.T) {
+	b := NewMemoryBackplane()
+	head := Journal().Seq()
+	for n := 0; n < 3; n++ {
+		if err := b.Publish(testEnvelope(n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for n := 0; n < 3; n++ {
+		seq, got := receive(t, b)
+		if seq != head+uint64(n)+1 || got != n {
+			t.Fatalf("got event %d with seq %d, want %d with seq %d", got, seq, n, head+uint64(n)+1)
+		}
+	}
+
+	b.Close()
+	b.Close()
+	if err := b.Publish(testEnvelope(3)); !errors.Is(err, errBackplaneClosed) {
+		t.Fatalf("publish after close: %v", err)
+	}
+	if _, ok := <-b.Messages(); ok {
+		t.Fatal("messages not closed")
+	}
+}
+
+// testDB connects to TEST_DATABASE_DSN, a MySQL database the test may write
+// to, and empties ws_broadcasts.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN not set")
+	}
+	db, err := gorm.Open(gormmysql.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.WsBroadcast{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Where("1 = 1").Delete(&models.WsBroadcast{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestDBBackplane(t *testing.T, db *gorm.DB, gapWait time.Duration) Backplane {
+	t.Helper()
+	b, err := NewDBBackplane(db, 20*time.Millisecond, gapWait, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.Close)
+	return b
+}
+
+func TestDBBackplane(t *testing.T) {
+	db := testDB(t)
+	a := newTestDBBackplane(t, db, time.Second)
+	b := newTestDBBackplane(t, db, time.Second)
+
+	// both instances see the events of both in the same order and numbering
+	for n := 0; n < 10; n++ {
+		publisher := a
+		if n%2 == 1 {
+			publisher = b
+		}
+		if err := publisher.Publish(testEnvelope(n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var last uint64
+	for n := 0; n < 10; n++ {
+		seqA, gotA := receive(t, a)
+		seqB, gotB := receive(t, b)
+		if seqA != seqB || gotA != n || gotB != n {
+			t.Fatalf("event %d: instance a got %d (seq %d), b got %d (seq %d)", n, gotA, seqA, gotB, seqB)
+		}
+		if seqA <= last {
+			t.Fatalf("seq %d after %d", seqA, last)
+		}
+		last = seqA
+	}
+}
+
+// insertUncommitted inserts event n in a transaction that is left open.
+func insertUncommitted(t *testing.T, db *gorm.DB, n int) *gorm.DB {
+	t.Helper()
+	e := testEnvelope(n)
+	envelope, _ := json.Marshal(e)
+	legacy, _ := e.Legacy()
+	tx := db.Begin()
+	if err := tx.Create(&models.WsBroadcast{Type: e.Type, Envelope: string(envelope), Legacy: string(legacy)}).Error; err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestDBBackplaneLateCommit(t *testing.T) {
+	db := testDB(t)
+	a := newTestDBBackplane(t, db, 2*time.Second)
+	b := newTestDBBackplane(t, db, 2*time.Second)
+
+	// event 0 gets the lower ID but commits after event 1
+	tx := insertUncommitted(t, db, 0)
+	if err := b.Publish(testEnvelope(1)); err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+	expectNothing(t, a, 300*time.Millisecond)
+	if err := tx.Commit().Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, bp := range []Backplane{a, b} {
+		for n := 0; n < 2; n++ {
+			if _, got := receive(t, bp); got != n {
+				t.Fatalf("got event %d, want %d", got, n)
+			}
+		}
+	}
+}
+
+func TestDBBackplaneRollback(t *testing.T) {
+	db := testDB(t)
+	const gapWait = 500 * time.Millisecond
+	a := newTestDBBackplane(t, db, gapWait)
+	b := newTestDBBackplane(t, db, gapWait)
+
+	// the rolled back insert leaves a hole that is skipped after gapWait
+	tx := insertUncommitted(t, db, 0)
+	published := time.Now()
+	if err := b.Publish(testEnvelope(1)); err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+	tx.Rollback()
+
+	for _, bp := range []Backplane{a, b} {
+		if _, got := receive(t, bp); got != 1 {
+			t.Fatalf("got event %d, want 1", got)
+		}
+	}
+	if elapsed := time.Since(published); elapsed < gapWait {
+		t.Fatalf("hole skipped after %s, want about %s", elapsed, gapWait)
+	}
+}
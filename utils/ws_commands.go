@@ -0,0 +1,197 @@
+package utils
+
+import (
+	"comproBackend/events"
+	"comproBackend/facesvc"
+	"comproBackend/services"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	maxCommandIDLength = 64
+	maxPendingCommands = 4 // per client
+)
+
+// wsCommand is a request a client sends with action "command". roles lists
+// who may run it; empty means every authenticated user. run returns an HTTP
+// status code, the result and, on failure, a message for the client.
+type wsCommand struct {
+	roles []string
+	run   func(c *Client, params json.RawMessage) (int, interface{}, error)
+}
+
+var wsCommands = map[string]wsCommand{
+	"camera.start":    {roles: []string{"verificator", "service"}, run: cmdCameraStart},
+	"camera.stop":     {roles: []string{"verificator", "service"}, run: cmdCameraStop},
+	"camera.snapshot": {run: cmdCameraSnapshot},
+	"door.unlock":     {roles: []string{"verificator"}, run: cmdDoorUnlock},
+	"alert.ack":       {run: cmdAlertAck},
+}
+
+// runCommand checks a command and runs it in the background; its
+// command_result reply carries the client's correlation ID. Resulting state
+// changes are broadcast like their REST counterparts.
+func (c *Client) runCommand(ctl wsControl) {
+	reply := events.CommandResultPayload{ID: ctl.ID, Command: ctl.Command}
+	if ctl.ID == "" || len(ctl.ID) > maxCommandIDLength {
+		c.replyCommand(reply, http.StatusBadRequest, nil, errors.New("id is required, at most 64 characters"))
+		return
+	}
+	cmd, ok := wsCommands[ctl.Command]
+	if !ok {
+		names := make([]string, 0, len(wsCommands))
+		for name := range wsCommands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		c.replyCommand(reply, http.StatusNotFound, nil, errors.New("unknown command, use "+strings.Join(names, ", ")))
+		return
+	}
+	if len(cmd.roles) > 0 && !slices.Contains(cmd.roles, c.Role) {
+		c.replyCommand(reply, http.StatusForbidden, nil, errors.New("Insufficient permissions"))
+		return
+	}
+
+	select {
+	case c.commands <- struct{}{}:
+	default:
+		c.replyCommand(reply, http.StatusTooManyRequests, nil, errors.New("too many commands in progress"))
+		return
+	}
+	go func() {
+		defer func() { <-c.commands }()
+		status, result, err := cmd.run(c, ctl.Params)
+		c.replyCommand(reply, status, result, err)
+	}()
+}
+
+func (c *Client) replyCommand(reply events.CommandResultPayload, status int, result interface{}, err error) {
+	reply.Status = status
+	reply.Result = result
+	reply.OK = err == nil && status < http.StatusBadRequest
+	if err != nil {
+		reply.Error = err.Error()
+	} else if !reply.OK {
+		reply.Error = http.StatusText(status)
+		if m, ok := result.(map[string]interface{}); ok {
+			if msg, ok := m["error"].(string); ok {
+				reply.Error = msg
+			}
+		}
+	}
+	c.reply(events.TypeCommandResult, reply, map[string]interface{}{"type": events.TypeCommandResult, "data": reply})
+}
+
+func decodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 || string(params) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return errors.New("invalid params")
+	}
+	return nil
+}
+
+func cmdCameraStart(c *Client, _ json.RawMessage) (int, interface{}, error) {
+	result, err := facesvc.Default().StartCamera(context.Background())
+	if err != nil {
+		return faceServiceFailure(err, "failed to start camera")
+	}
+	Publish(events.New(events.TypeCameraStatus, events.SourceBackend, events.CameraStatusPayload{Action: "start", Result: result}))
+	return http.StatusOK, result, nil
+}
+
+func cmdCameraStop(c *Client, _ json.RawMessage) (int, interface{}, error) {
+	result, err := facesvc.Default().StopCamera(context.Background())
+	if err != nil {
+		return faceServiceFailure(err, "failed to stop camera")
+	}
+	Publish(events.New(events.TypeCameraStatus, events.SourceBackend, events.CameraStatusPayload{Action: "stop", Result: result}))
+	return http.StatusOK, result, nil
+}
+
+// faceServiceFailure turns a failed face service call into a command reply,
+// the same way the REST handlers answer it.
+func faceServiceFailure(err error, message string) (int, interface{}, error) {
+	status, body, text := facesvc.Failure(err, message)
+	return status, body, errors.New(text)
+}
+
+// cmdCameraSnapshot returns the current frame as base64 JPEG.
+func cmdCameraSnapshot(c *Client, _ json.RawMessage) (int, interface{}, error) {
+	frame, err := services.TakeSnapshot()
+	if err != nil {
+		log.Println("Camera snapshot error:", err)
+		return http.StatusBadGateway, nil, errors.New("failed to get snapshot")
+	}
+	return http.StatusOK, map[string]interface{}{
+		"content_type": "image/jpeg",
+		"size":         len(frame),
+		"captured_at":  time.Now().UTC(),
+		"data":         base64.StdEncoding.EncodeToString(frame),
+	}, nil
+}
+
+func cmdDoorUnlock(c *Client, params json.RawMessage) (int, interface{}, error) {
+	var input struct {
+		Door string `json:"door"`
+	}
+	if err := decodeParams(params, &input); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	if input.Door = strings.TrimSpace(input.Door); input.Door == "" {
+		return http.StatusBadRequest, nil, errors.New("door is required")
+	}
+
+	status, result, err := services.UnlockDoor(input.Door, c.Username)
+	if err != nil {
+		if errors.Is(err, services.ErrDoorUnlockNotConfigured) {
+			return http.StatusServiceUnavailable, nil, err
+		}
+		log.Println("Door unlock error:", err)
+		if errors.Is(err, services.ErrDoorBadResponse) {
+			return http.StatusBadGateway, nil, services.ErrDoorBadResponse
+		}
+		return http.StatusBadGateway, nil, errors.New("failed to reach door controller")
+	}
+	if status < http.StatusMultipleChoices {
+		Publish(events.New(events.TypeDoorUnlock, events.SourceBackend, events.DoorUnlockPayload{Door: input.Door, RequestedBy: c.Username, Result: result}))
+	}
+	return status, result, nil
+}
+
+// cmdAlertAck sets the status of an anomaly, acknowledged unless given.
+func cmdAlertAck(c *Client, params json.RawMessage) (int, interface{}, error) {
+	input := struct {
+		ID     uint   `json:"id"`
+		Status string `json:"status"`
+	}{Status: "acknowledged"}
+	if err := decodeParams(params, &input); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	if input.ID == 0 {
+		return http.StatusBadRequest, nil, errors.New("id is required")
+	}
+
+	anomaly, err := services.ReviewAnomaly(input.ID, input.Status, c.Username)
+	switch {
+	case errors.Is(err, services.ErrAnomalyNotFound):
+		return http.StatusNotFound, nil, err
+	case errors.Is(err, services.ErrInvalidAnomalyStatus):
+		return http.StatusBadRequest, nil, err
+	case err != nil:
+		log.Println("DB error:", err)
+		return http.StatusInternalServerError, nil, errors.New("db failed")
+	}
+	Publish(events.New(events.TypeAnomalyReview, events.SourceBackend, events.NewAnomalyReviewPayload(anomaly)))
+	return http.StatusOK, anomaly, nil
+}
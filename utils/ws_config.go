@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Slow consumer policies: what the hub does when a client's send buffer is
+// full.
+const (
+	SlowClientDisconnect = "disconnect" // close it; it can resume with last_seq
+	SlowClientDrop       = "drop"       // skip the event for that client only
+)
+
+const wsMaxMessageSize = 64 << 10
+
+// hubSettings are the keepalive and backpressure settings of the hub.
+type hubSettings struct {
+	PingInterval     time.Duration
+	PongTimeout      time.Duration
+	WriteTimeout     time.Duration
+	SendBuffer       int
+	SlowClientPolicy string
+}
+
+var (
+	hubSettingsOnce sync.Once
+	hubConfig       hubSettings
+)
+
+// wsHubSettings reads WS_PING_INTERVAL, WS_PONG_TIMEOUT, WS_WRITE_TIMEOUT,
+// WS_SEND_BUFFER and WS_SLOW_CLIENT_POLICY on first use.
+func wsHubSettings() hubSettings {
+	hubSettingsOnce.Do(func() {
+		hubConfig = hubSettings{
+			PingInterval:     envWsDuration("WS_PING_INTERVAL", 30*time.Second),
+			PongTimeout:      envWsDuration("WS_PONG_TIMEOUT", 60*time.Second),
+			WriteTimeout:     envWsDuration("WS_WRITE_TIMEOUT", 10*time.Second),
+			SendBuffer:       256,
+			SlowClientPolicy: SlowClientDisconnect,
+		}
+		if v, err := strconv.Atoi(os.Getenv("WS_SEND_BUFFER")); err == nil && v > 0 {
+			hubConfig.SendBuffer = v
+		}
+		switch policy := os.Getenv("WS_SLOW_CLIENT_POLICY"); policy {
+		case "":
+		case SlowClientDisconnect, SlowClientDrop:
+			hubConfig.SlowClientPolicy = policy
+		default:
+			log.Printf("Warning: invalid WS_SLOW_CLIENT_POLICY %q, using %s", policy, SlowClientDisconnect)
+		}
+		// a pong has to be able to arrive before the read deadline passes
+		if hubConfig.PongTimeout <= hubConfig.PingInterval {
+			hubConfig.PongTimeout = 2 * hubConfig.PingInterval
+		}
+	})
+	return hubConfig
+}
+
+func envWsDuration(name string, fallback time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("Warning: invalid %s %q, using %s", name, v, fallback)
+		return fallback
+	}
+	return d
+}
@@ -0,0 +1,183 @@
+package utils
+
+import (
+	"comproBackend/config"
+	"comproBackend/models"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const wsJournalPrune = time.Hour
+
+// journal keeps the last published events so reconnecting clients can catch
+// up. Sequence numbers start at the boot time in milliseconds, so they keep
+// increasing across restarts even without persistence; events from before a
+// restart are then reported as a gap.
+type journal struct {
+	mu      sync.Mutex
+	ring    []hubMessage
+	start   int // index of the oldest event in ring
+	count   int
+	head    uint64 // last assigned sequence number
+	first   uint64 // oldest sequence number that can still be replayed
+	persist chan hubMessage
+}
+
+var (
+	wsJournalOnce sync.Once
+	wsJournal     *journal
+)
+
+// Journal returns the event journal, configured from WS_JOURNAL_SIZE,
+// WS_JOURNAL_PERSIST and WS_JOURNAL_RETENTION on first use. With the database
+// backplane the journal is refilled from the backplane table instead.
+func Journal() *journal {
+	wsJournalOnce.Do(func() {
+		size := 1000
+		if v, err := strconv.Atoi(os.Getenv("WS_JOURNAL_SIZE")); err == nil && v > 0 {
+			size = v
+		}
+		retention := wsJournalRetention()
+		persist, _ := strconv.ParseBool(os.Getenv("WS_JOURNAL_PERSIST"))
+
+		j := &journal{ring: make([]hubMessage, size), head: uint64(time.Now().UnixMilli())}
+		if h, ok := wsBackplane().(backplaneHistory); ok {
+			messages, head, err := h.history(size, retention)
+			if err != nil {
+				log.Printf("Warning: failed to load WebSocket journal: %v", err)
+			} else {
+				for _, m := range messages {
+					j.add(m)
+				}
+				j.head = head
+				log.Printf("WebSocket journal restored %d events", len(messages))
+			}
+		} else if persist && config.DB != nil {
+			if err := j.load(retention); err != nil {
+				log.Printf("Warning: failed to load WebSocket journal: %v", err)
+			}
+			j.persist = make(chan hubMessage, 1024)
+			go j.writeLoop(retention)
+		}
+		j.first = j.head + 1
+		if j.count > 0 {
+			j.first = j.ring[j.start].seq
+		}
+		wsJournal = j
+	})
+	return wsJournal
+}
+
+func wsJournalRetention() time.Duration {
+	retention := 24 * time.Hour
+	if v, err := time.ParseDuration(os.Getenv("WS_JOURNAL_RETENTION")); err == nil && v > 0 {
+		retention = v
+	}
+	return retention
+}
+
+// record appends a message delivered by the backplane. Messages at or below
+// the head are already journaled.
+func (j *journal) record(message hubMessage) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if message.seq <= j.head {
+		return
+	}
+	j.head = message.seq
+	j.add(message)
+
+	if j.persist != nil {
+		select {
+		case j.persist <- message:
+		default:
+			log.Println("WebSocket journal writer is behind, event not persisted")
+		}
+	}
+}
+
+func (j *journal) add(message hubMessage) {
+	if j.count < len(j.ring) {
+		j.ring[(j.start+j.count)%len(j.ring)] = message
+		j.count++
+	} else {
+		j.ring[j.start] = message
+		j.start = (j.start + 1) % len(j.ring)
+	}
+	j.first = j.ring[j.start].seq
+}
+
+// since returns the events after lastSeq and the latest sequence number. ok
+// is false when some of them are gone and the client has to resync.
+func (j *journal) since(lastSeq uint64) (missed []hubMessage, head, first uint64, ok bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if lastSeq > j.head || lastSeq+1 < j.first {
+		return nil, j.head, j.first, false
+	}
+	for i := 0; i < j.count; i++ {
+		m := j.ring[(j.start+i)%len(j.ring)]
+		if m.seq > lastSeq {
+			missed = append(missed, m)
+		}
+	}
+	return missed, j.head, j.first, true
+}
+
+// Seq returns the latest sequence number.
+func (j *journal) Seq() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.head
+}
+
+// load refills the ring from the database and continues its numbering.
+func (j *journal) load(retention time.Duration) error {
+	var rows []models.WsEvent
+	if err := config.DB.Where("created_at >= ?", time.Now().Add(-retention)).
+		Order("seq DESC").Limit(len(j.ring)).Find(&rows).Error; err != nil {
+		return err
+	}
+	for i := len(rows) - 1; i >= 0; i-- {
+		r := rows[i]
+		j.add(hubMessage{seq: r.Seq, topic: r.Topic, cameraID: r.CameraID, name: r.Name, envelope: []byte(r.Envelope), legacy: []byte(r.Legacy)})
+	}
+
+	var maxSeq uint64
+	if err := config.DB.Model(&models.WsEvent{}).Select("COALESCE(MAX(seq), 0)").Scan(&maxSeq).Error; err != nil {
+		return err
+	}
+	if maxSeq > j.head {
+		j.head = maxSeq
+	}
+	log.Printf("WebSocket journal restored %d events", len(rows))
+	return nil
+}
+
+// writeLoop stores journaled events and prunes those past retention.
+func (j *journal) writeLoop(retention time.Duration) {
+	prune := time.NewTicker(wsJournalPrune)
+	defer prune.Stop()
+	for {
+		select {
+		case m := <-j.persist:
+			var e struct {
+				Type string `json:"type"`
+			}
+			_ = json.Unmarshal(m.envelope, &e)
+			row := models.WsEvent{Seq: m.seq, Type: e.Type, Topic: m.topic, CameraID: m.cameraID, Name: m.name, Envelope: string(m.envelope), Legacy: string(m.legacy)}
+			if err := config.DB.Create(&row).Error; err != nil {
+				log.Printf("Failed to persist WebSocket event %d: %v", m.seq, err)
+			}
+		case <-prune.C:
+			if err := config.DB.Where("created_at < ?", time.Now().Add(-retention)).Delete(&models.WsEvent{}).Error; err != nil {
+				log.Printf("Failed to prune WebSocket journal: %v", err)
+			}
+		}
+	}
+}
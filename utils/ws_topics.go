@@ -0,0 +1,170 @@
+package utils
+
+import (
+	"comproBackend/events"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// WebSocket topics a client can subscribe to.
+const (
+	TopicDetections = "detections" // live recognitions from the camera stream
+	TopicLogs       = "logs"       // stored logs, reviews, notes and tags
+	TopicCamera     = "camera"     // camera status and other camera service events
+	TopicApprovals  = "approvals"  // registrations and reset requests, verificator only
+	TopicAlerts     = "alerts"     // anomalies
+)
+
+var WsTopics = []string{TopicDetections, TopicLogs, TopicCamera, TopicApprovals, TopicAlerts}
+
+var eventTopics = map[string]string{
+	events.TypeDetection:     TopicDetections,
+	events.TypeLog:           TopicLogs,
+	events.TypeLogReview:     TopicLogs,
+	events.TypeLogNote:       TopicLogs,
+	events.TypeLogTags:       TopicLogs,
+	events.TypeCameraStatus:  TopicCamera,
+	events.TypeCameraEvent:   TopicCamera,
+	events.TypeUserPending:   TopicApprovals,
+	events.TypeUserApproval:  TopicApprovals,
+	events.TypeAnomaly:       TopicAlerts,
+	events.TypeAnomalyReview: TopicAlerts,
+	events.TypeDoorUnlock:    TopicCamera,
+}
+
+// topicForEvent maps an event type to its topic. Unknown types come from the
+// camera service stream.
+func topicForEvent(eventType string) string {
+	if topic, ok := eventTopics[eventType]; ok {
+		return topic
+	}
+	return TopicCamera
+}
+
+func topicAllowed(topic, role string) bool {
+	if topic == TopicApprovals {
+		return role == "verificator" || role == "service"
+	}
+	return true
+}
+
+// wsControl is a subscription request or command sent by a client.
+type wsControl struct {
+	Action   string   `json:"action"`   // hello | subscribe | unsubscribe | subscriptions | command
+	Versions []int    `json:"versions"` // hello: protocol versions the client speaks
+	Topics   []string `json:"topics"`
+	Cameras  []string `json:"cameras"`
+	Names    []string `json:"names"`
+
+	// command: ID is echoed in the command_result reply
+	ID      string          `json:"id"`
+	Command string          `json:"command"`
+	Params  json.RawMessage `json:"params"`
+}
+
+// subscription is what a client wants to receive and in which protocol
+// version. Camera and name filters
+// only apply to events that carry a camera_id or name; empty means all.
+type subscription struct {
+	mu      sync.Mutex
+	version int
+	role    string
+	topics  map[string]bool
+	cameras map[string]bool
+	names   map[string]bool
+}
+
+// newSubscription starts with every topic the role may see, so clients that
+// never subscribe keep receiving everything as before.
+func newSubscription(role string, protocol int) *subscription {
+	s := &subscription{version: protocol, role: role, topics: map[string]bool{}, cameras: map[string]bool{}, names: map[string]bool{}}
+	for _, topic := range WsTopics {
+		if topicAllowed(topic, role) {
+			s.topics[topic] = true
+		}
+	}
+	return s
+}
+
+func (s *subscription) protocol() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.version
+}
+
+func (s *subscription) setProtocol(version int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version = version
+}
+
+func (s *subscription) matches(m hubMessage) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.topics[m.topic] {
+		return false
+	}
+	if len(s.cameras) > 0 && m.cameraID != "" && !s.cameras[m.cameraID] {
+		return false
+	}
+	if len(s.names) > 0 && m.name != "" && !s.names[strings.ToLower(m.name)] {
+		return false
+	}
+	return true
+}
+
+// apply runs a subscribe or unsubscribe request.
+func (s *subscription) apply(ctl wsControl) error {
+	for _, topic := range ctl.Topics {
+		if !slices.Contains(WsTopics, topic) {
+			return fmt.Errorf("unknown topic %q, use %s", topic, strings.Join(WsTopics, ", "))
+		}
+		if !topicAllowed(topic, s.role) {
+			return fmt.Errorf("topic %q is not allowed for role %s", topic, s.role)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subscribe := ctl.Action == "subscribe"
+	for _, topic := range ctl.Topics {
+		setMember(s.topics, topic, subscribe)
+	}
+	for _, camera := range ctl.Cameras {
+		setMember(s.cameras, strings.TrimSpace(camera), subscribe)
+	}
+	for _, name := range ctl.Names {
+		setMember(s.names, strings.ToLower(strings.TrimSpace(name)), subscribe)
+	}
+	return nil
+}
+
+func setMember(set map[string]bool, key string, present bool) {
+	if key == "" {
+		return
+	}
+	if present {
+		set[key] = true
+	} else {
+		delete(set, key)
+	}
+}
+
+func (s *subscription) snapshot() map[string][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return map[string][]string{"topics": sortedKeys(s.topics), "cameras": sortedKeys(s.cameras), "names": sortedKeys(s.names)}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}